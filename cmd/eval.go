@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -54,16 +55,30 @@ var evalCmd = &cobra.Command{
 			u = uri.URI("stdin")
 		}
 
-		_, items := lang.NewStringLexer(string(data))
-		p := lang.NewParser(items, u)
+		lex := lang.NewStringLexer(string(data))
+		p := lang.NewParser(lex, u)
 		ast := p.Parse()
 		if perrs := lang.CollectParseErrors(ast); len(perrs) > 0 {
-			return perrs[0]
+			// report every syntax error, not just the first
+			errs := make([]error, len(perrs))
+			for i, perr := range perrs {
+				errs[i] = perr
+			}
+			return errors.Join(errs...)
 		}
 		res, err := vm.EvalWithDir(ast, baseDir, u)
 		if err != nil {
 			return err
 		}
+		if _, ok := ast.(*lang.Documents); ok {
+			for i, doc := range res.([]interface{}) {
+				if i > 0 {
+					fmt.Println("---")
+				}
+				fmt.Print(types.InspectValue(doc))
+			}
+			return nil
+		}
 		fmt.Print(types.InspectValue(res))
 		return nil
 	},
@@ -48,7 +48,11 @@ func Execute() {
 
 	cmd, err := RootCmd.ExecuteC()
 	if err != nil {
-		if _, ok := err.(lang.NostosError); !ok {
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		if _, ok := errs[0].(lang.NostosError); !ok {
 			_ = cmd.Usage()
 		}
 		if len(os.Args) > 1 && os.Args[1] == "lsp" {
@@ -61,7 +65,7 @@ func Execute() {
 				f = report.NewSimpleFormatter()
 			}
 			r := report.New(f, os.Stdout)
-			r.Report([]error{err})
+			r.Report(errs)
 		}
 		os.Exit(1)
 	}
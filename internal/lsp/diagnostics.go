@@ -11,11 +11,17 @@ func langPosToProtocol(p lang.Position) protocol.Position {
 	return protocol.Position{Line: uint32(p.LineNumber), Character: uint32(p.CharacterOffset)}
 }
 
+// langRange is the range of source text spanned by n
+func langRange(n interface {
+	Pos() lang.Position
+	End() lang.Position
+}) protocol.Range {
+	return protocol.Range{Start: langPosToProtocol(n.Pos()), End: langPosToProtocol(n.End())}
+}
+
 func diagnosticFromParseError(e *lang.ParseError) protocol.Diagnostic {
-	pos := langPosToProtocol(e.Pos())
-	rng := protocol.Range{Start: pos, End: pos}
 	return protocol.Diagnostic{
-		Range:    rng,
+		Range:    langRange(e),
 		Severity: protocol.DiagnosticSeverityError,
 		Source:   "nostos",
 		Message:  e.Error(),
@@ -24,10 +30,8 @@ func diagnosticFromParseError(e *lang.ParseError) protocol.Diagnostic {
 
 func diagnosticFromError(err error) protocol.Diagnostic {
 	if ne, ok := err.(lang.NostosError); ok {
-		pos := langPosToProtocol(ne.Pos())
-		rng := protocol.Range{Start: pos, End: pos}
 		return protocol.Diagnostic{
-			Range:    rng,
+			Range:    langRange(ne),
 			Severity: protocol.DiagnosticSeverityError,
 			Source:   "nostos",
 			Message:  ne.Error(),
@@ -52,7 +56,9 @@ func diagnosticsFromParseErrors(n interface{}) []protocol.Diagnostic {
 }
 
 func evalForDiagnostics(n interface{}, base string, u uri.URI) ([]protocol.Diagnostic, interface{}) {
-	val, err := vm.EvalWithDir(n, base, u)
+	// the file may be a library imported by others, so functions
+	// in its value aren't an error
+	val, err := vm.EvalModule(n, base, u)
 	if err != nil {
 		return []protocol.Diagnostic{diagnosticFromError(err)}, nil
 	}
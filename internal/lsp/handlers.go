@@ -231,20 +231,25 @@ func (h Handler) Hover(ctx context.Context, params *protocol.HoverParams) (*prot
 	ast := lang.NewAst(text, uri.URI(params.TextDocument.URI))
 	diags := diagnosticsFromParseErrors(ast.RootNode)
 
-	var msg string
+	var (
+		msg string
+		rng *protocol.Range // the error being shown, if any
+	)
 	if len(diags) > 0 {
 		msg = diags[0].Message
+		rng = &diags[0].Range
 	} else {
 		evalDiags, val := evalForDiagnostics(ast.RootNode, filepath.Dir(uri.URI(params.TextDocument.URI).Filename()), uri.URI(params.TextDocument.URI))
 		if len(evalDiags) > 0 {
 			msg = evalDiags[0].Message
+			rng = &evalDiags[0].Range
 		} else {
 			msg = fmt.Sprintf("%v", val)
 		}
 	}
 
 	contents := protocol.MarkupContent{Kind: protocol.PlainText, Value: msg}
-	return &protocol.Hover{Contents: contents}, nil
+	return &protocol.Hover{Contents: contents, Range: rng}, nil
 }
 
 // Completion provides completions using parsed symbols from the document.
@@ -13,6 +13,8 @@ import (
 	"go.lsp.dev/uri"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+
+	"github.com/wycleffsean/nostos/pkg/ordered"
 )
 
 // testStreamServer adapts the lsp.StartServer logic for in-memory streams.
@@ -223,8 +225,8 @@ func TestOdysseyEvaluation(t *testing.T) {
 		env.handler.state.mu.RLock()
 		val := env.handler.state.odyssey
 		env.handler.state.mu.RUnlock()
-		if m, ok := val.(map[string]interface{}); ok {
-			if _, ok := m["cluster"]; ok {
+		if m, ok := val.(*ordered.Map); ok {
+			if _, ok := m.Get("cluster"); ok {
 				return
 			}
 		}
@@ -1,30 +1,18 @@
 package lang
 
-import (
-	"sync"
-
-	"go.lsp.dev/uri"
-)
+import "go.lsp.dev/uri"
 
 type Ast struct {
 	Document uri.URI
 	RootNode node
+	Comments []*CommentGroup // all comments in the document
 }
 
 func NewAst(input string, uri uri.URI) Ast {
-	lexer := &lexer{input: input, items: make(chan item)}
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	go func() {
-		defer wg.Done()
-		lexer.run()
-	}()
-
-	parser := NewParser(lexer.items, uri)
+	parser := NewParser(NewStringLexer(input), uri)
 	parsedItem := parser.Parse()
 
-	return Ast{uri, parsedItem}
+	return Ast{uri, parsedItem, parser.Comments()}
 }
 
 func (a *Ast) ExtractSymbols() []*Symbol {
@@ -41,10 +29,15 @@ func extractSymbols(ast_node node) []*Symbol {
 	case collectionNode:
 		symbols = append(symbols, extractSymbolsCollection(node)...)
 	case *Let:
-		for k, v := range *node.Bindings {
-			key := k
-			symbols = append(symbols, &key)
-			symbols = append(symbols, extractSymbols(v)...)
+		for _, entry := range *node.Bindings {
+			if entry.Pattern != nil {
+				for _, field := range entry.Pattern.Fields {
+					symbols = append(symbols, &field.Name)
+				}
+			} else {
+				symbols = append(symbols, &entry.Key)
+			}
+			symbols = append(symbols, extractSymbols(entry.Value)...)
 		}
 		symbols = append(symbols, extractSymbols(node.Body)...)
 	default:
@@ -0,0 +1,11 @@
+package lang
+
+// Boolean is a true/false literal.
+type Boolean struct {
+	Position Position
+	Value    bool
+}
+
+func (b *Boolean) Pos() Position { return b.Position }
+
+func (b *Boolean) End() Position { return asciiEnd(b.Position) }
@@ -1,13 +1,13 @@
 package lang
 
-// Call represents a function call with a single argument.
+// Call represents a function call, `f(a, b)`. EndPosition is
+// just past the closing paren.
 type Call struct {
-	Func node
-	Arg  node
+	Func        node
+	Args        []node
+	EndPosition Position
 }
 
 func (c *Call) Pos() Position { return c.Func.Pos() }
 
-func (c *Call) leftExpr() node { return c.Func }
-
-func (c *Call) rightExpr() node { return c.Arg }
+func (c *Call) End() Position { return c.EndPosition }
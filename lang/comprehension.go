@@ -0,0 +1,33 @@
+package lang
+
+// Comprehension builds a list, [for t in tenants: f(t)], or a map,
+// {for t in tenants: t.name: t.port}, with an entry for each item of
+// a collection. Filter, from `if cond` after the collection, is nil
+// when every item is kept.
+//
+// Vars holds one or two *Symbol or *Pattern. The last is bound to
+// each item, a preceding one to its index in a list or its key in
+// a map, [for i, x in xs: ...].
+type Comprehension struct {
+	Position    Position
+	Vars        []node
+	Iterable    node
+	Filter      node
+	Key         node // nil in a list comprehension
+	Value       node
+	EndPosition Position
+}
+
+func (c *Comprehension) Pos() Position { return c.Position }
+
+func (c *Comprehension) End() Position { return c.EndPosition }
+
+func (c *Comprehension) Symbols() []node {
+	symbols := append([]node{}, c.Vars...)
+	for _, n := range []node{c.Iterable, c.Filter, c.Key, c.Value} {
+		if n != nil {
+			symbols = append(symbols, n)
+		}
+	}
+	return symbols
+}
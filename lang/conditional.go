@@ -0,0 +1,20 @@
+package lang
+
+// Conditional represents an if-then-else expression. Else is nil
+// when the expression has no else branch, in which case a false
+// condition omits the map key or list item holding it.
+type Conditional struct {
+	Position Position
+	Cond     node
+	Then     node
+	Else     node
+}
+
+func (c *Conditional) Pos() Position { return c.Position }
+
+func (c *Conditional) End() Position {
+	if c.Else != nil {
+		return c.Else.End()
+	}
+	return c.Then.End()
+}
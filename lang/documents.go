@@ -0,0 +1,26 @@
+package lang
+
+// Documents is a stream of YAML documents separated by `---`.
+// Empty documents are dropped, so every entry is a root node.
+type Documents []node
+
+func (d *Documents) Pos() Position {
+	if d == nil || len(*d) == 0 {
+		return Position{}
+	}
+	return (*d)[0].Pos()
+}
+
+func (d *Documents) End() Position {
+	if d == nil || len(*d) == 0 {
+		return Position{}
+	}
+	return (*d)[len(*d)-1].End()
+}
+
+func (d *Documents) Symbols() []node {
+	if d == nil {
+		return nil
+	}
+	return *d
+}
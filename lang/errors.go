@@ -1,10 +1,49 @@
 package lang
 
+import "sort"
+
+// Invalid is the root of a document with syntax errors outside
+// of any map or list that could hold them, e.g. a stray token
+// between two top level keys. Root is whatever parsed around
+// the errors, and may be nil.
+type Invalid struct {
+	Root   node
+	Errors []node
+}
+
+func (i *Invalid) Pos() Position {
+	if i.Root != nil {
+		return i.Root.Pos()
+	}
+	return i.Errors[0].Pos()
+}
+
+func (i *Invalid) End() Position {
+	end := i.Errors[len(i.Errors)-1].End()
+	if i.Root != nil {
+		if e := i.Root.End(); end.Less(e) {
+			end = e
+		}
+	}
+	return end
+}
+
+func (i *Invalid) Symbols() []node {
+	if i.Root == nil {
+		return nil
+	}
+	return []node{i.Root}
+}
+
 // CollectParseErrors recursively collects any ParseError nodes in the provided
-// AST node and returns them.
+// AST node and returns them in source order.
 func CollectParseErrors(n interface{}) []*ParseError {
 	var errs []*ParseError
 	collectParseErrors(n, &errs)
+	sort.SliceStable(errs, func(i, j int) bool {
+		pos := errs[i].Pos()
+		return pos.Less(errs[j].Pos())
+	})
 	return errs
 }
 
@@ -13,23 +52,73 @@ func collectParseErrors(n interface{}, errs *[]*ParseError) {
 	case *ParseError:
 		*errs = append(*errs, t)
 	case *List:
+		for _, c := range *t {
+			collectParseErrors(c.Value, errs)
+		}
+	case *Documents:
 		for _, c := range *t {
 			collectParseErrors(c, errs)
 		}
 	case *Map:
-		for k, v := range *t {
-			collectParseErrors(&k, errs)
-			collectParseErrors(v, errs)
+		for _, entry := range *t {
+			collectParseErrors(&entry.Key, errs)
+			if entry.Pattern != nil {
+				collectParseErrors(entry.Pattern, errs)
+			}
+			collectParseErrors(entry.Value, errs)
+		}
+	case *Flow:
+		collectParseErrors(t.Collection, errs)
+	case *Group:
+		collectParseErrors(t.Expr, errs)
+	case *Comprehension:
+		for _, n := range t.Symbols() {
+			collectParseErrors(n, errs)
+		}
+	case *Pattern:
+		for _, field := range t.Fields {
+			if field.Default != nil {
+				collectParseErrors(field.Default, errs)
+			}
+		}
+	case *Interpolation:
+		for _, part := range t.Parts {
+			collectParseErrors(part, errs)
 		}
 	case *Call:
 		collectParseErrors(t.Func, errs)
-		collectParseErrors(t.Arg, errs)
+		for _, arg := range t.Args {
+			collectParseErrors(arg, errs)
+		}
 	case *Function:
-		collectParseErrors(t.Param, errs)
+		for _, param := range t.Params {
+			collectParseErrors(param, errs)
+		}
 		collectParseErrors(t.Body, errs)
+	case *BinaryOp:
+		collectParseErrors(t.Left, errs)
+		collectParseErrors(t.Right, errs)
+	case *UnaryOp:
+		collectParseErrors(t.Operand, errs)
 	case *Shovel:
 		collectParseErrors(t.Left, errs)
 		collectParseErrors(t.Right, errs)
+	case *Select:
+		collectParseErrors(t.Expr, errs)
+	case *Index:
+		collectParseErrors(t.Expr, errs)
+		collectParseErrors(t.Key, errs)
+	case *Conditional:
+		collectParseErrors(t.Cond, errs)
+		collectParseErrors(t.Then, errs)
+		if t.Else != nil {
+			collectParseErrors(t.Else, errs)
+		}
+	case *Invalid:
+		collectParseErrors(t.Root, errs)
+		for _, e := range t.Errors {
+			collectParseErrors(e, errs)
+		}
 	case *Let:
 		collectParseErrors(t.Bindings, errs)
 		collectParseErrors(t.Body, errs)
@@ -0,0 +1,21 @@
+package lang
+
+// Flow is a flow collection, {app: web} or [80, 443], spanning its
+// brackets. Collection is the *Map or *List inside them, which has
+// no position of its own when it's empty.
+type Flow struct {
+	Position    Position // the opening bracket
+	Collection  node
+	EndPosition Position // just past the closing bracket
+}
+
+func (f *Flow) Pos() Position { return f.Position }
+
+func (f *Flow) End() Position { return f.EndPosition }
+
+func (f *Flow) Symbols() []node {
+	if c, ok := f.Collection.(collectionNode); ok {
+		return c.Symbols()
+	}
+	return nil
+}
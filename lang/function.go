@@ -1,13 +1,21 @@
 package lang
 
-// Function represents a lambda expression with a single parameter.
+// Function represents a lambda expression, `x => body` or
+// `(x, y) => body`. Curried functions are nested lambdas,
+// `x => y => body`. Each parameter is a *Symbol or a *Pattern
+// destructuring its argument, `{name, port} => body`.
 type Function struct {
-	Param *Symbol
-	Body  node
+	Position Position // the parameter, or the paren before them
+	Params   []node
+	Body     node
 }
 
-func (f *Function) Pos() Position { return f.Param.Pos() }
+func (f *Function) Pos() Position { return f.Position }
+
+func (f *Function) End() Position { return f.Body.End() }
 
 func (f *Function) Symbols() []node {
-	return []node{f.Param, f.Body}
+	symbols := make([]node, 0, len(f.Params)+1)
+	symbols = append(symbols, f.Params...)
+	return append(symbols, f.Body)
 }
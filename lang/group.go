@@ -0,0 +1,26 @@
+package lang
+
+// Group is a parenthesized expression, (a + b), spanning its
+// parentheses. It evaluates to Expr.
+type Group struct {
+	Position    Position // the opening paren
+	Expr        node
+	EndPosition Position // just past the closing paren
+}
+
+func (g *Group) Pos() Position { return g.Position }
+
+func (g *Group) End() Position { return g.EndPosition }
+
+func (g *Group) Symbols() []node { return []node{g.Expr} }
+
+// Ungroup returns the expression inside any parentheses around n
+func Ungroup(n interface{}) interface{} {
+	for {
+		g, ok := n.(*Group)
+		if !ok {
+			return n
+		}
+		n = g.Expr
+	}
+}
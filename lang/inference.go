@@ -6,6 +6,9 @@ import "github.com/wycleffsean/nostos/pkg/types"
 // the required fields of the types stored in the registry. It returns the
 // matching TypeDefinition and true if exactly one match is found.
 func InferType(n node, reg *types.Registry) (*types.ObjectType, bool) {
+	if f, ok := n.(*Flow); ok {
+		n = f.Collection
+	}
 	m, ok := n.(*Map)
 	if !ok {
 		return nil, false
@@ -13,8 +16,8 @@ func InferType(n node, reg *types.Registry) (*types.ObjectType, bool) {
 
 	// Build a set of field names present in the map
 	fieldNames := make(map[string]struct{})
-	for sym := range *m {
-		fieldNames[sym.Text] = struct{}{}
+	for _, entry := range *m {
+		fieldNames[entry.Key.Text] = struct{}{}
 	}
 
 	matches := make([]*types.ObjectType, 0)
@@ -9,8 +9,8 @@ import (
 )
 
 func parseManifest(input string) node {
-	_, items := NewStringLexer(input)
-	parser := NewParser(items, uri.URI("test"))
+	lex := NewStringLexer(input)
+	parser := NewParser(lex, uri.URI("test"))
 	return parser.Parse()
 }
 
@@ -0,0 +1,77 @@
+package lang
+
+// Interpolation is a string literal with embedded ${...}
+// expressions, e.g. "${registry}/${image}:${tag}". Parts holds
+// the literal *String segments and the embedded expressions in
+// source order.
+type Interpolation struct {
+	Position    Position
+	Parts       []node
+	EndPosition Position
+}
+
+func (i *Interpolation) Pos() Position { return i.Position }
+
+func (i *Interpolation) End() Position { return i.EndPosition }
+
+func (i *Interpolation) Symbols() []node { return i.Parts }
+
+// skipString returns the index of the double quote closing the
+// string whose contents begin at s[i]
+func skipString(s string, i int) int {
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		case '$':
+			if i+1 < len(s) && s[i+1] == '{' {
+				i = skipInterpolation(s, i+2)
+			}
+		}
+	}
+	return len(s)
+}
+
+// skipInterpolation returns the index of the '}' closing the
+// embedded expression beginning at s[i]
+func skipInterpolation(s string, i int) int {
+	depth := 0
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case '"':
+			i = skipString(s, i+1)
+		}
+	}
+	return len(s)
+}
+
+// advance moves pos over text, tracking lines and characters
+// the same way the lexer does
+func advance(pos Position, text string) Position {
+	for _, r := range text {
+		if r == '\n' {
+			pos.LineNumber++
+			pos.CharacterOffset = 0
+		} else {
+			pos.CharacterOffset++
+		}
+	}
+	pos.ByteOffset += uint(len(text))
+	return pos
+}
+
+// endOf is the position just past text found at pos
+func endOf(pos Position, text string) Position {
+	end := advance(pos, text)
+	end.ByteLength = 0
+	return end
+}
@@ -18,19 +18,49 @@ func _() {
 	_ = x[itemColon-7]
 	_ = x[itemArrow-8]
 	_ = x[itemShovel-9]
-	_ = x[itemLeftParen-10]
-	_ = x[itemRightParen-11]
-	_ = x[itemNumber-12]
-	_ = x[itemString-13]
-	_ = x[itemPath-14]
-	_ = x[itemSymbol-15]
-	_ = x[itemLet-16]
-	_ = x[itemIn-17]
+	_ = x[itemMergeKey-10]
+	_ = x[itemLeftParen-11]
+	_ = x[itemRightParen-12]
+	_ = x[itemNumber-13]
+	_ = x[itemString-14]
+	_ = x[itemRawString-15]
+	_ = x[itemPath-16]
+	_ = x[itemSymbol-17]
+	_ = x[itemLet-18]
+	_ = x[itemIn-19]
+	_ = x[itemComment-20]
+	_ = x[itemBool-21]
+	_ = x[itemNull-22]
+	_ = x[itemPlus-23]
+	_ = x[itemMinus-24]
+	_ = x[itemStar-25]
+	_ = x[itemSlash-26]
+	_ = x[itemPercent-27]
+	_ = x[itemEqual-28]
+	_ = x[itemNotEqual-29]
+	_ = x[itemLess-30]
+	_ = x[itemLessEqual-31]
+	_ = x[itemGreater-32]
+	_ = x[itemGreaterEqual-33]
+	_ = x[itemAnd-34]
+	_ = x[itemOr-35]
+	_ = x[itemBang-36]
+	_ = x[itemBlockString-37]
+	_ = x[itemLeftBrace-38]
+	_ = x[itemRightBrace-39]
+	_ = x[itemLeftBracket-40]
+	_ = x[itemRightBracket-41]
+	_ = x[itemComma-42]
+	_ = x[itemIf-43]
+	_ = x[itemThen-44]
+	_ = x[itemElse-45]
+	_ = x[itemAnchor-46]
+	_ = x[itemAssign-47]
 }
 
-const _itemType_name = "itemUndefineditemErroritemDotitemDocStartitemDocEnditemEOFitemListitemColonitemArrowitemShovelitemLeftParenitemRightParenitemNumberitemStringitemPathitemSymbolitemLetitemIn"
+const _itemType_name = "itemUndefineditemErroritemDotitemDocStartitemDocEnditemEOFitemListitemColonitemArrowitemShovelitemMergeKeyitemLeftParenitemRightParenitemNumberitemStringitemRawStringitemPathitemSymbolitemLetitemInitemCommentitemBoolitemNullitemPlusitemMinusitemStaritemSlashitemPercentitemEqualitemNotEqualitemLessitemLessEqualitemGreateritemGreaterEqualitemAnditemOritemBangitemBlockStringitemLeftBraceitemRightBraceitemLeftBracketitemRightBracketitemCommaitemIfitemThenitemElseitemAnchoritemAssign"
 
-var _itemType_index = [...]uint8{0, 13, 22, 29, 41, 51, 58, 66, 75, 84, 94, 107, 121, 131, 141, 149, 159, 166, 172}
+var _itemType_index = [...]uint16{0, 13, 22, 29, 41, 51, 58, 66, 75, 84, 94, 106, 119, 133, 143, 153, 166, 174, 184, 191, 197, 208, 216, 224, 232, 241, 249, 258, 269, 278, 290, 298, 311, 322, 338, 345, 351, 359, 374, 387, 401, 416, 432, 441, 447, 455, 463, 473, 483}
 
 func (i itemType) String() string {
 	if i < 0 || i >= itemType(len(_itemType_index)-1) {
@@ -8,3 +8,5 @@ type Let struct {
 }
 
 func (l *Let) Pos() Position { return l.Position }
+
+func (l *Let) End() Position { return l.Body.End() }
@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -15,14 +16,15 @@ const (
 	itemUndefined itemType = iota // undefined should never occur, we have a lexing error;
 	itemError                     // error occurred;
 	// value is text of error
-	itemDot // the cursor, spelled '.'
-	itemDocStart
-	itemDocEnd
+	itemDot      // the cursor, spelled '.'
+	itemDocStart // ---
+	itemDocEnd   // ...
 	itemEOF
 	itemList
 	itemColon
 	itemArrow      // =>
 	itemShovel     // <<
+	itemMergeKey   // << followed by :, the key of a YAML merge
 	itemLeftParen  // (
 	itemRightParen // )
 	// itemElse
@@ -34,13 +36,41 @@ const (
 	itemNumber
 	// itemPipe
 	// itemRange
-	// itemRawString
 	// itemRightMeta
 	itemString
+	itemRawString // single quoted or `raw` string, without escapes or interpolation
 	itemPath
 	itemSymbol
 	itemLet
 	itemIn
+	itemComment      // # through to the end of the line
+	itemBool         // true, false and their YAML spellings
+	itemNull         // null, ~ and their YAML spellings
+	itemPlus         // +
+	itemMinus        // -
+	itemStar         // *
+	itemSlash        // /
+	itemPercent      // %
+	itemEqual        // ==
+	itemNotEqual     // !=
+	itemLess         // <
+	itemLessEqual    // <=
+	itemGreater      // >
+	itemGreaterEqual // >=
+	itemAnd          // &&
+	itemOr           // ||
+	itemBang         // !
+	itemBlockString  // | or > block scalar, with its raw content lines
+	itemLeftBrace    // {
+	itemRightBrace   // }
+	itemLeftBracket  // [
+	itemRightBracket // ]
+	itemComma        // ,
+	itemIf           // if
+	itemThen         // then
+	itemElse         // else
+	itemAnchor       // &name, a YAML anchor
+	itemAssign       // =, a default in a pattern
 
 // itemText
 )
@@ -49,6 +79,7 @@ type item struct {
 	typ      itemType // Type, such as itemNumber
 	val      string   // Value, such as "23.2"
 	position Position
+	end      Position // just past the last character of the token
 	indent   uint
 }
 
@@ -63,6 +94,16 @@ type Position struct {
 	CharacterOffset uint `json:"character"`
 }
 
+// asciiEnd is the position just past a single line token of
+// ASCII characters, such as a number, found at p
+func asciiEnd(p Position) Position {
+	return Position{
+		ByteOffset:      p.ByteOffset + p.ByteLength,
+		LineNumber:      p.LineNumber,
+		CharacterOffset: p.CharacterOffset + p.ByteLength,
+	}
+}
+
 func (p *Position) Less(than Position) bool {
 	if p.LineNumber < than.LineNumber {
 		return true
@@ -96,30 +137,59 @@ type lexer struct {
 	offset        uint   // 0 indexed count of character offset - corresponds to LSP spec PositionEncodingKind
 	currentOffset uint   // counter for offset
 	currentIndent uint
-	width         uint      // width of last rune read
-	items         chan item // channel of scanned items
+	midLine       bool     // a token other than a list marker was emitted on this line
+	prev          itemType // type of the last item emitted
+	width         uint     // width of last rune read
+	items         []item   // scanned items not yet returned by NextToken
+	head          int      // index of the next item to return
+	state         stateFn  // next state to run, nil once the input is exhausted
+	origin        Position // where input begins within the enclosing document
+	flowDepth     uint     // nesting of flow collections, {...} and [...]
+	parenDepth    uint     // nesting of parentheses, as around call arguments
 }
 
 type stateFn func(*lexer) stateFn
 
-func NewStringLexer(input string) (*lexer, <-chan item) {
-	l := &lexer{
+func NewStringLexer(input string) *lexer {
+	return &lexer{
 		input: input,
-		items: make(chan item),
+		state: lexFile,
+	}
+}
+
+// newFragmentLexer lexes a fragment of a larger document, such as
+// an expression embedded in a string. Positions are reported
+// relative to the enclosing document by offsetting them by origin.
+func newFragmentLexer(input string, origin Position) *lexer {
+	return &lexer{
+		input:  input,
+		state:  lexFile,
+		origin: origin,
+		// an interpolation is an expression in the middle of a
+		// line, never the start of a list item
+		midLine: true,
 	}
-	go l.run() // Concurrent run state machine
-	return l, l.items
 }
 
 func GetFunctionName(i interface{}) string {
 	return runtime.FuncForPC(reflect.ValueOf(i).Pointer()).Name()
 }
 
-func (l *lexer) run() {
-	for state := lexFile; state != nil; {
-		state = state(l)
+// NextToken returns the next item in the input, running the state
+// machine only until it has emitted one. States may emit several
+// items at once, so they're queued. Once the input is exhausted
+// NextToken keeps returning itemEOF.
+func (l *lexer) NextToken() item {
+	for l.head == len(l.items) {
+		l.items, l.head = l.items[:0], 0
+		if l.state == nil {
+			return item{typ: itemEOF}
+		}
+		l.state = l.state(l)
 	}
-	close(l.items) // No more tokens will be delivered
+	tok := l.items[l.head]
+	l.head++
+	return tok
 }
 
 // Sometimes we want the lex token and the offset to be different
@@ -131,10 +201,48 @@ func (l *lexer) markOffset() {
 }
 
 func (l *lexer) emit(t itemType) {
-	position := Position{l.start, l.pos - l.start, l.currentLine, l.offset}
-	l.publish(item{t, l.input[l.start:l.pos], position, l.currentIndent})
+	position := l.at(Position{l.start, l.pos - l.start, l.currentLine, l.offset})
+	l.publish(item{t, l.input[l.start:l.pos], position, l.cursor(), l.currentIndent})
 	l.markOffset()
 	l.start = l.pos
+	l.prev = t
+	if t != itemList {
+		l.midLine = true
+	}
+}
+
+// at translates a position within the input into one within
+// the enclosing document (see newFragmentLexer)
+func (l *lexer) at(p Position) Position {
+	if p.LineNumber == 0 {
+		p.CharacterOffset += l.origin.CharacterOffset
+	}
+	p.ByteOffset += l.origin.ByteOffset
+	p.LineNumber += l.origin.LineNumber
+	return p
+}
+
+// cursor is the position in the document of the next rune
+func (l *lexer) cursor() Position {
+	return l.at(Position{ByteOffset: l.pos, LineNumber: l.currentLine, CharacterOffset: l.currentOffset})
+}
+
+// extend moves the end of the last emitted item up to the cursor,
+// for tokens whose value leaves out some of their text, like the
+// closing quote of a string
+func (l *lexer) extend() {
+	l.items[len(l.items)-1].end = l.cursor()
+}
+
+// lexToken emits the fixed token text, such as an operator,
+// found at the cursor
+func (l *lexer) lexToken(text string, t itemType) stateFn {
+	l.ignore()
+	l.markOffset()
+	l.pos += uint(len(text))
+	l.currentOffset += uint(utf8.RuneCountInString(text))
+	l.emit(t)
+	return lexInDocument
 }
 
 func (l *lexer) next() rune {
@@ -160,6 +268,9 @@ func (l *lexer) ignore() {
 // backup steps back one rune
 // Can be called only once per call of next
 func (l *lexer) backup() {
+	if l.width == 0 {
+		return // next hit EOF and didn't advance
+	}
 	l.pos -= l.width
 	l.currentOffset -= 1
 }
@@ -189,20 +300,28 @@ func (l *lexer) acceptRun(valid string) {
 	l.backup()
 }
 
+// errorf emits an error token and carries on lexing from the next
+// line, so the parser can recover and report later errors too. Flow
+// collections and parentheses left open on the line are abandoned.
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
-	position := Position{l.start, l.pos - l.start, l.currentLine, l.currentOffset}
+	position := l.at(Position{l.start, l.pos - l.start, l.currentLine, l.currentOffset})
 	message := fmt.Sprintf(format, args...)
-	l.publish(item{itemError, message, position, l.currentIndent})
-	return nil
+	l.publish(item{itemError, message, position, l.cursor(), l.currentIndent})
+	for r := l.peek(); r != '\n' && r != 0; r = l.peek() {
+		l.next()
+	}
+	l.ignore()
+	l.flowDepth, l.parenDepth = 0, 0
+	return lexInDocument
 }
 
 func (l *lexer) publish(item item) {
 	// fmt.Printf("<- %v\n", item)
-	l.items <- item
+	l.items = append(l.items, item)
 }
 
 func isAlpha(r rune) bool {
-	return r >= 'A' && r <= 'z'
+	return unicode.IsLetter(r) || r == '_'
 }
 
 func isNumber(r rune) bool {
@@ -213,6 +332,8 @@ func isAlphaNumeric(r rune) bool {
 	return isAlpha(r) || isNumber(r)
 }
 
+// isValidKey reports whether r can continue a symbol. Keys with
+// other characters, like spaces or colons, must be quoted.
 func isValidKey(r rune) bool {
 	return isAlphaNumeric(r) || r == '/' || r == '.' || r == '-'
 }
@@ -230,6 +351,32 @@ func isPathStart(l *lexer) bool {
 	return false
 }
 
+// isDelimiter reports whether r can end a scalar
+func isDelimiter(r rune) bool {
+	switch r {
+	case 0, ' ', '\n', ':', ')', ',', ']', '}':
+		return true
+	}
+	return false
+}
+
+// isDocumentMarker reports whether the input is at a `---` or
+// `...` marker, which must start a line and stand on its own
+func isDocumentMarker(l *lexer, marker string) bool {
+	if l.pos != 0 && l.input[l.pos-1] != '\n' {
+		return false
+	}
+	if !strings.HasPrefix(l.input[l.pos:], marker) {
+		return false
+	}
+	rest := l.input[l.pos+uint(len(marker)):]
+	return rest == "" || rest[0] == ' ' || rest[0] == '\n'
+}
+
+func isCommentLine(l *lexer) bool {
+	return strings.HasPrefix(strings.TrimLeft(l.input[l.pos:], " "), "#")
+}
+
 func isPathRune(r rune) bool {
 	switch r {
 	case 0, ' ', '\n', '\t', ':', '(', ')':
@@ -245,6 +392,7 @@ func lexFile(l *lexer) stateFn {
 
 func lexInDocument(l *lexer) stateFn {
 	r := l.peek()
+	rest := l.input[l.pos:]
 	switch r {
 	case 0:
 		l.emit(itemEOF)
@@ -254,6 +402,9 @@ func lexInDocument(l *lexer) stateFn {
 	case '\t':
 		return l.errorf("horizontal tabs are not supported")
 	case '.':
+		if isDocumentMarker(l, "...") {
+			return l.lexToken("...", itemDocEnd)
+		}
 		if isPathStart(l) {
 			return lexPath
 		}
@@ -261,66 +412,157 @@ func lexInDocument(l *lexer) stateFn {
 		l.emit(itemDot)
 		return lexInDocument
 	case '/':
+		if len(rest) == 1 || rest[1] == ' ' || rest[1] == '\n' {
+			return l.lexToken("/", itemSlash)
+		}
 		return lexPath
 	case '-':
-		return lexList
+		if isDocumentMarker(l, "---") {
+			return l.lexToken("---", itemDocStart)
+		}
+		return lexDash
 	case '(':
+		l.parenDepth++
 		l.next()
 		l.emit(itemLeftParen)
 		return lexInDocument
 	case ')':
+		if l.parenDepth > 0 {
+			l.parenDepth--
+		}
 		l.next()
 		l.emit(itemRightParen)
 		return lexInDocument
+	case '+':
+		return l.lexToken("+", itemPlus)
+	case '*':
+		return l.lexToken("*", itemStar)
+	case '%':
+		return l.lexToken("%", itemPercent)
 	case '=':
-		l.next()
-		if l.next() == '>' {
-			l.emit(itemArrow)
-			return lexInDocument
+		if strings.HasPrefix(rest, "=>") {
+			return l.lexToken("=>", itemArrow)
+		}
+		if strings.HasPrefix(rest, "==") {
+			return l.lexToken("==", itemEqual)
+		}
+		return l.lexToken("=", itemAssign)
+	case '!':
+		if strings.HasPrefix(rest, "!=") {
+			return l.lexToken("!=", itemNotEqual)
 		}
-		return l.errorf("unexpected '='")
+		return l.lexToken("!", itemBang)
 	case '<':
-		l.next()
-		if l.next() == '<' {
-			l.emit(itemShovel)
-			return lexInDocument
+		if strings.HasPrefix(rest, "<<:") {
+			return l.lexToken("<<", itemMergeKey)
+		}
+		if strings.HasPrefix(rest, "<<") {
+			return l.lexToken("<<", itemShovel)
+		}
+		if strings.HasPrefix(rest, "<=") {
+			return l.lexToken("<=", itemLessEqual)
+		}
+		return l.lexToken("<", itemLess)
+	case '>':
+		if isBlockScalarHeader(rest) {
+			return lexBlockScalar
+		}
+		if strings.HasPrefix(rest, ">=") {
+			return l.lexToken(">=", itemGreaterEqual)
+		}
+		return l.lexToken(">", itemGreater)
+	case '&':
+		if strings.HasPrefix(rest, "&&") {
+			return l.lexToken("&&", itemAnd)
+		}
+		return lexAnchor
+	case '|':
+		if isBlockScalarHeader(rest) {
+			return lexBlockScalar
 		}
-		return l.errorf("unexpected '<'")
+		if strings.HasPrefix(rest, "||") {
+			return l.lexToken("||", itemOr)
+		}
+		return l.errorf("unexpected '|'")
 	case ':':
 		l.next()
 		l.emit(itemColon)
 		return lexInDocument
-	default:
+	case '{':
+		l.flowDepth++
+		return l.lexToken("{", itemLeftBrace)
+	case '[':
+		l.flowDepth++
+		return l.lexToken("[", itemLeftBracket)
+	case '}':
+		if l.flowDepth > 0 {
+			l.flowDepth--
+		}
+		return l.lexToken("}", itemRightBrace)
+	case ']':
+		if l.flowDepth > 0 {
+			l.flowDepth--
+		}
+		return l.lexToken("]", itemRightBracket)
+	case ',':
+		return l.lexToken(",", itemComma)
+	case '#':
+		return lexComment
+	case '~':
+		l.next()
+		if !isDelimiter(l.peek()) {
+			return l.errorf("unexpected '~'")
+		}
+		l.emit(itemNull)
+		return lexInDocument
+	case ' ':
 		l.acceptRun(" ")
 		l.ignore()
-		if isNumber(l.peek()) {
+		l.markOffset()
+		return lexInDocument
+	default:
+		l.ignore()
+		if isNumber(r) {
 			return lexNumber
 		}
-		if l.peek() == '"' {
+		if r == '"' {
 			return lexString
 		}
-		if isAlpha(l.peek()) {
+		if r == '\'' {
+			return lexSingleQuoted
+		}
+		if r == '`' {
+			return lexRawString
+		}
+		if isAlpha(r) {
 			return lexSymbol
 		}
-		// TODO: there's a potential for infinite loops here, we need
-		//   to redesign the lexer in a way that forces the cursor to make
-		//   progress at all times
-		// if there's a bug in the lexer we've likely hit this branch
-		// fmt.Printf("===lexInDocument - hit default case with r='%c'\n", r)
-		return lexInDocument
+		l.next()
+		return l.errorf("unexpected character %q", r)
 	}
 }
 
 // a newline or a prior indent can get us here
 func lexIndent(l *lexer) stateFn {
+	if l.flowDepth > 0 {
+		return lexFlowIndent
+	}
 	if l.peek() == '\n' {
 		l.next()
 		l.ignore()
 		l.currentLine += 1
 		l.currentOffset = 0
 		l.currentIndent = 0
+		l.midLine = false
 		l.markOffset()
 	}
+	// comments may be indented arbitrarily, they don't take
+	// part in the indentation structure of the document
+	if isCommentLine(l) {
+		l.acceptRun(" ")
+		l.ignore()
+		return lexComment
+	}
 	if l.peek() == ' ' {
 		l.next()
 		if l.next() != ' ' {
@@ -335,6 +577,70 @@ func lexIndent(l *lexer) stateFn {
 	return lexInDocument
 }
 
+// lexFlowIndent skips line breaks within a flow collection, where
+// indentation carries no meaning. Tokens keep the indentation of
+// the line the collection began on.
+func lexFlowIndent(l *lexer) stateFn {
+	if l.peek() == '\n' {
+		l.next()
+		l.currentLine += 1
+		l.currentOffset = 0
+	}
+	l.acceptRun(" ")
+	l.ignore()
+	l.markOffset()
+	return lexInDocument
+}
+
+// lexDash disambiguates the uses of '-'. At the start of a line
+// "- " is a list item. Following an operand it's the minus
+// operator, a - b or 2 -1. Otherwise directly before a digit it's
+// the sign of a number. Before a letter it begins a plain scalar,
+// like the -c of `- -c` or [-c, --port], where a list item or flow
+// entry begins, and --port anywhere. Elsewhere, as in x: -y or
+// f(-y), it negates the expression it opens. Anything else is the
+// minus operator.
+func lexDash(l *lexer) stateFn {
+	var r rune
+	if l.pos+1 < uint(len(l.input)) {
+		r, _ = utf8.DecodeRuneInString(l.input[l.pos+1:])
+	}
+	switch {
+	case !l.midLine && (r == 0 || r == ' ' || r == '\n'):
+		return lexList
+	case l.midLine && isOperand(l.prev) && r != '-':
+		return l.lexToken("-", itemMinus)
+	case isNumber(r):
+		l.ignore()
+		return lexNumber
+	case r == '-' || isAlpha(r) && l.startsEntry():
+		l.ignore()
+		return lexSymbol
+	default:
+		return l.lexToken("-", itemMinus)
+	}
+}
+
+// isOperand reports whether an item of type typ can end an operand,
+// so a '-' following it is subtraction
+func isOperand(typ itemType) bool {
+	switch typ {
+	case itemSymbol, itemNumber, itemString, itemRawString, itemBool, itemNull, itemPath,
+		itemRightParen, itemRightBracket, itemRightBrace:
+		return true
+	}
+	return false
+}
+
+// startsEntry reports whether the next token begins a list item or
+// an entry of a flow collection
+func (l *lexer) startsEntry() bool {
+	if !l.midLine {
+		return true
+	}
+	return l.flowDepth > 0 && (l.prev == itemLeftBracket || l.prev == itemLeftBrace || l.prev == itemComma)
+}
+
 func lexList(l *lexer) stateFn {
 	listIndent := l.currentIndent
 	l.emit(itemList)
@@ -368,31 +674,267 @@ func lexSymbol(l *lexer) stateFn {
 		l.emit(itemLet)
 	case "in":
 		l.emit(itemIn)
+	case "if":
+		l.emit(itemIf)
+	case "then":
+		l.emit(itemThen)
+	case "else":
+		l.emit(itemElse)
+	case "true", "True", "TRUE", "false", "False", "FALSE":
+		l.emit(itemBool)
+	case "null", "Null", "NULL":
+		l.emit(itemNull)
 	default:
 		l.emit(itemSymbol)
 	}
 	return lexInDocument
 }
 
+// lexAnchor scans a YAML anchor, &name. Aliases, *name, are
+// lexed as '*' and a symbol, the parser tells them apart from
+// multiplication.
+func lexAnchor(l *lexer) stateFn {
+	l.next()
+	if !isValidKey(l.peek()) {
+		return l.errorf("unexpected '&'")
+	}
+	for isValidKey(l.peek()) {
+		l.next()
+	}
+	l.emit(itemAnchor)
+	return lexInDocument
+}
+
 func lexString(l *lexer) stateFn {
 	l.markOffset() // "character offset" for LSP will begin at double quote
 	if l.next() != '"' {
 		return l.errorf("Strings must be quoted")
 	}
 	l.ignore() // skip quote
+	from := l.pos
+	if msg := l.scanString(); msg != "" {
+		return l.unterminated(from, msg)
+	}
+	l.emit(itemString)
+	l.next() // consume and skip double quote
+	l.endString(from)
+	return lexInDocument
+}
+
+// lexSingleQuoted scans a YAML single quoted string. There are no
+// escapes other than a doubled quote for a quote, and no
+// interpolation.
+func lexSingleQuoted(l *lexer) stateFn {
+	l.markOffset()
+	l.next()
+	l.ignore() // skip quote
+	from := l.pos
+	for {
+		switch l.next() {
+		case 0:
+			return l.unterminated(from, "EOF reached in unterminated string")
+		case '\'':
+			if !strings.HasPrefix(l.input[l.pos:], "'") {
+				l.backup()
+				l.emit(itemRawString)
+				tok := &l.items[len(l.items)-1]
+				tok.val = strings.ReplaceAll(tok.val, "''", "'")
+				l.next() // consume and skip the closing quote
+				l.endString(from)
+				return lexInDocument
+			}
+			l.next()
+		}
+	}
+}
+
+// lexRawString scans a backtick quoted string, kept exactly as
+// written, for regular expressions and scripts full of backslashes
+// and quotes. It may span lines.
+func lexRawString(l *lexer) stateFn {
+	l.markOffset()
+	l.next()
+	l.ignore() // skip backtick
+	from := l.pos
+	for {
+		switch l.next() {
+		case 0:
+			return l.unterminated(from, "EOF reached in unterminated string")
+		case '`':
+			l.backup()
+			l.emit(itemRawString)
+			l.next() // consume and skip the closing backtick
+			l.endString(from)
+			return lexInDocument
+		}
+	}
+}
+
+// endString follows the closing quote of the string just emitted,
+// whose contents began at input[from]
+func (l *lexer) endString(from uint) {
+	l.countLines(from)
+	l.extend()
+	l.ignore()
+}
+
+// countLines sets the line and character offset of the cursor after
+// a string beginning at input[from]. The string may have spanned
+// lines, which next doesn't count.
+func (l *lexer) countLines(from uint) {
+	text := l.input[from:l.pos]
+	if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+		l.currentLine += uint(strings.Count(text, "\n"))
+		l.currentOffset = uint(utf8.RuneCountInString(text[i+1:]))
+	}
+}
+
+// unterminated reports a string whose contents began at input[from]
+// and ran to the end of the input. The error is placed at the
+// opening quote and spans the rest of its line.
+func (l *lexer) unterminated(from uint, msg string) stateFn {
+	quote := from - 1
+	eol := uint(len(l.input))
+	if i := strings.IndexByte(l.input[from:], '\n'); i >= 0 {
+		eol = from + uint(i)
+	}
+	position := l.at(Position{quote, eol - quote, l.currentLine, l.offset})
+	end := l.at(Position{
+		ByteOffset:      eol,
+		LineNumber:      l.currentLine,
+		CharacterOffset: l.offset + uint(utf8.RuneCountInString(l.input[quote:eol])),
+	})
+	l.publish(item{itemError, msg, position, end, l.currentIndent})
+	l.countLines(from)
+	l.ignore()
+	return lexInDocument
+}
+
+// scanString advances to the closing double quote of a string,
+// stepping over escapes and ${...} interpolations so that quotes
+// within an embedded expression don't end the string. It returns
+// an error message if the string is unterminated.
+func (l *lexer) scanString() string {
+	for {
+		switch l.next() {
+		case 0:
+			return "EOF reached in unterminated string"
+		case '\\':
+			l.next()
+		case '"':
+			l.backup()
+			return ""
+		case '$':
+			if l.peek() == '{' {
+				l.next()
+				if msg := l.scanInterpolation(); msg != "" {
+					return msg
+				}
+			}
+		}
+	}
+}
+
+// scanInterpolation advances past the '}' closing an embedded
+// expression, the opening "${" having already been consumed
+func (l *lexer) scanInterpolation() string {
+	depth := 0
+	for {
+		switch l.next() {
+		case 0:
+			return "EOF reached in unterminated interpolation"
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return ""
+			}
+			depth--
+		case '"':
+			if msg := l.scanString(); msg != "" {
+				return msg
+			}
+			l.next() // closing quote
+		}
+	}
+}
+
+// isBlockScalarHeader reports whether input begins with a block
+// scalar indicator (| or >), an optional chomping indicator (- or +)
+// and nothing else on the line but an optional comment
+func isBlockScalarHeader(input string) bool {
+	header := strings.TrimLeft(input[1:], "+-")
+	if len(input)-len(header) > 2 {
+		return false
+	}
+	trimmed := strings.TrimLeft(header, " ")
+	if trimmed == "" || trimmed[0] == '\n' {
+		return true
+	}
+	return trimmed[0] == '#' && len(trimmed) < len(header)
+}
+
+// lexBlockScalar scans a literal (|) or folded (>) block scalar.
+// Its content is every following line indented further than the
+// line holding the header, along with any blank lines among them.
+// The item carries the header and the raw content lines; the
+// parser strips the indentation and applies folding and chomping.
+func lexBlockScalar(l *lexer) stateFn {
+	lineStart := strings.LastIndexByte(l.input[:l.pos], '\n') + 1
+	parent := len(l.input[lineStart:]) - len(strings.TrimLeft(l.input[lineStart:], " "))
+
+	l.ignore()
+	l.markOffset()
+	l.next()
+	l.accept("+-")
+	for r := l.peek(); r != '\n' && r != 0; r = l.peek() {
+		l.next() // trailing spaces and comment
+	}
+
+	indent := -1
+	lines := uint(0)
+	for l.peek() == '\n' {
+		line := l.input[l.pos+1:]
+		if i := strings.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i]
+		} else if line == "" {
+			break // a trailing newline at EOF doesn't begin a line
+		}
+		if strings.TrimLeft(line, " ") != "" {
+			spaces := len(line) - len(strings.TrimLeft(line, " "))
+			if indent < 0 {
+				if spaces <= parent {
+					break
+				}
+				indent = spaces
+			}
+			if spaces < indent {
+				break
+			}
+		}
+		l.pos += uint(len(line)) + 1
+		lines++
+	}
+	l.emit(itemBlockString)
+	// content lines were consumed here rather than by lexIndent
+	tok := &l.items[len(l.items)-1]
+	tok.end = endOf(tok.position, tok.val)
+	l.currentLine += lines
+	return lexInDocument
+}
+
+// lexComment scans a '#' comment through to the end of the line.
+// The '#' is kept in the item so the parser can build Comment nodes
+func lexComment(l *lexer) stateFn {
+	l.markOffset()
 	for {
 		r := l.peek()
-		if r == 0 {
-			l.ignore()
-			return l.errorf("EOF reached in unterminated string")
-		} else if r == '"' && l.input[l.pos-1] != '\\' {
+		if r == '\n' || r == 0 {
 			break
 		}
 		l.next()
 	}
-	l.emit(itemString)
-	l.next() // consume and skip double quote
-	l.ignore()
+	l.emit(itemComment)
 	return lexInDocument
 }
 
@@ -423,7 +965,13 @@ func lexNumber(l *lexer) stateFn {
 }
 
 func lexPath(l *lexer) stateFn {
-	for isPathRune(l.peek()) {
+	for r := l.peek(); isPathRune(r); r = l.peek() {
+		if l.flowDepth > 0 && (r == ',' || r == ']' || r == '}') {
+			break
+		}
+		if l.parenDepth > 0 && r == ',' {
+			break
+		}
 		l.next()
 	}
 	l.emit(itemPath)
@@ -8,9 +8,9 @@ func FuzzLex(f *testing.F) {
 		f.Add(s)
 	}
 	f.Fuzz(func(t *testing.T, input string) {
-		_, items := NewStringLexer(input)
-		for range items {
-			// drain items until closed
+		l := NewStringLexer(input)
+		for l.NextToken().typ != itemEOF {
+			// drain items until the input is exhausted
 		}
 	})
 }
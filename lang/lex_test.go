@@ -1,40 +1,43 @@
 package lang
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
 // Test Helpers
 
-func assertEOF(t *testing.T, items <-chan item) {
-	eof := <-items
+func assertEOF(t *testing.T, l *lexer) {
+	eof := l.NextToken()
 	if eof.typ != itemEOF {
 		t.Errorf("Expecting EOF but got %s", eof.typ)
 	}
-	if item, ok := <-items; ok {
+	// the lexer is exhausted and keeps returning EOF
+	if item := l.NextToken(); item.typ != itemEOF {
 		t.Errorf("got item after EOF  %q", item)
 	}
 }
 
-func single(t *testing.T, items <-chan item) item {
-	res := <-items
-	assertEOF(t, items)
+func single(t *testing.T, l *lexer) item {
+	res := l.NextToken()
+	assertEOF(t, l)
 	return res
 }
 
-func pair(t *testing.T, items <-chan item) (item, item) {
-	itema := <-items
-	itemb := <-items
-	assertEOF(t, items)
+func pair(t *testing.T, l *lexer) (item, item) {
+	itema := l.NextToken()
+	itemb := l.NextToken()
+	assertEOF(t, l)
 	return itema, itemb
 }
 
-func keyValue(t *testing.T, items <-chan item) (item, item) {
-	itema := <-items
-	colon := <-items
-	itemb := <-items
+func keyValue(t *testing.T, l *lexer) (item, item) {
+	itema := l.NextToken()
+	colon := l.NextToken()
+	itemb := l.NextToken()
 	assertScalar(t, colon, itemColon, ":", 0)
-	assertEOF(t, items)
+	assertEOF(t, l)
 	return itema, itemb
 }
 
@@ -71,119 +74,200 @@ func assertPosition(t *testing.T, got item, value string, byteOffset, byteLength
 // Tests
 
 func TestLexSymbol(t *testing.T) {
-	_, items := NewStringLexer("yo")
-	got := single(t, items)
+	l := NewStringLexer("yo")
+	got := single(t, l)
 	assertScalar(t, got, itemSymbol, "yo", 0)
 }
 
 func TestLexString(t *testing.T) {
-	_, items := NewStringLexer(`"yo"`)
-	got := single(t, items)
+	l := NewStringLexer(`"yo"`)
+	got := single(t, l)
 	assertScalar(t, got, itemString, "yo", 0)
 }
 
 func TestLexStringWithSymbols(t *testing.T) {
-	_, items := NewStringLexer(`"apps/v1"`)
-	got := single(t, items)
+	l := NewStringLexer(`"apps/v1"`)
+	got := single(t, l)
 	assertScalar(t, got, itemString, "apps/v1", 0)
 }
 
 func TestLexPathAbsolute(t *testing.T) {
-	_, items := NewStringLexer("/etc/passwd")
-	got := single(t, items)
+	l := NewStringLexer("/etc/passwd")
+	got := single(t, l)
 	assertScalar(t, got, itemPath, "/etc/passwd", 0)
 }
 
 func TestLexPathRelative(t *testing.T) {
-	_, items := NewStringLexer("../foo")
-	got := single(t, items)
+	l := NewStringLexer("../foo")
+	got := single(t, l)
 	assertScalar(t, got, itemPath, "../foo", 0)
 }
 
+func TestLexPathArgument(t *testing.T) {
+	l := NewStringLexer("readFile(./dir, x)")
+	assertScalar(t, l.NextToken(), itemSymbol, "readFile", 0)
+	l.NextToken()
+	assertScalar(t, l.NextToken(), itemPath, "./dir", 0)
+	if tok := l.NextToken(); tok.typ != itemComma {
+		t.Fatalf("expected a comma got %s", tok.typ)
+	}
+}
+
 func TestLexInteger(t *testing.T) {
-	_, items := NewStringLexer("123")
-	got := single(t, items)
+	l := NewStringLexer("123")
+	got := single(t, l)
 	assertScalar(t, got, itemNumber, "123", 0)
 }
 
 func TestLexFloat(t *testing.T) {
-	_, items := NewStringLexer("123.99")
-	got := single(t, items)
+	l := NewStringLexer("123.99")
+	got := single(t, l)
 	assertScalar(t, got, itemNumber, "123.99", 0)
 }
 
 func TestLexList(t *testing.T) {
-	_, items := NewStringLexer("- yo")
-	itema, itemb := pair(t, items)
+	l := NewStringLexer("- yo")
+	itema, itemb := pair(t, l)
 	assertScalar(t, itema, itemList, "", 0)
 	assertScalar(t, itemb, itemSymbol, "yo", 1)
 }
 
 func TestLexListSymbolPosition(t *testing.T) {
-	_, items := NewStringLexer("- foo")
-	list := <-items
-	sym := <-items
+	l := NewStringLexer("- foo")
+	list := l.NextToken()
+	sym := l.NextToken()
 	assertScalar(t, list, itemList, "", 0)
 	assertPosition(t, sym, "foo", 2, 3, 0, 2)
-	assertEOF(t, items)
+	assertEOF(t, l)
 }
 
 func TestLexMap(t *testing.T) {
-	_, items := NewStringLexer("foo: bar")
-	key, value := keyValue(t, items)
+	l := NewStringLexer("foo: bar")
+	key, value := keyValue(t, l)
 	assertScalar(t, key, itemSymbol, "foo", 0)
 	assertScalar(t, value, itemSymbol, "bar", 0)
 }
 
-// TODO: Should lexer drop escape backslashes?
+// the lexer keeps escapes as written, the parser decodes them
 func TestLexQuotedString(t *testing.T) {
-	_, items := NewStringLexer(`foo: "this is a \"quoted\" string"`)
-	key, value := keyValue(t, items)
+	l := NewStringLexer(`foo: "this is a \"quoted\" string"`)
+	key, value := keyValue(t, l)
 	assertScalar(t, key, itemSymbol, "foo", 0)
 	assertScalar(t, value, itemString, `this is a \"quoted\" string`, 0)
 }
 
 func TestLexQuotedStringUnterminated(t *testing.T) {
-	_, items := NewStringLexer(`foo: "unterminated`)
-	key := <-items
-	colon := <-items
-	value := <-items
+	l := NewStringLexer(`foo: "unterminated`)
+	key := l.NextToken()
+	colon := l.NextToken()
+	value := l.NextToken()
 	assertScalar(t, key, itemSymbol, "foo", 0)
 	assertScalar(t, colon, itemColon, ":", 0)
 	assertScalar(t, value, itemError, "EOF reached in unterminated string", 0)
 }
 
+func TestLexUnterminatedStringPosition(t *testing.T) {
+	// the error is at the opening quote, spanning the rest of its line
+	for _, input := range []string{"a: \"abc\nd", "a: 'abc\nd", "a: `abc\nd", "a: \"${abc\nd"} {
+		l := NewStringLexer(input)
+		l.NextToken()
+		l.NextToken()
+		got := l.NextToken()
+		if got.typ != itemError {
+			t.Fatalf("%q: expected an error got %s", input, got)
+		}
+		if pos := got.position; pos.LineNumber != 0 || pos.CharacterOffset != 3 || pos.ByteOffset != 3 {
+			t.Errorf("%q: expected the error at 0:3 got %d:%d", input, pos.LineNumber, pos.CharacterOffset)
+		}
+		if end := got.end; end.LineNumber != 0 || end.CharacterOffset != uint(len(input)-2) {
+			t.Errorf("%q: expected the error to end at 0:%d got %d:%d", input, len(input)-2, end.LineNumber, end.CharacterOffset)
+		}
+		assertEOF(t, l)
+	}
+}
+
+func TestLexSingleQuotedString(t *testing.T) {
+	l := NewStringLexer(`foo: 'it''s ${not} "interpolated"'`)
+	key, value := keyValue(t, l)
+	assertScalar(t, key, itemSymbol, "foo", 0)
+	assertScalar(t, value, itemRawString, `it's ${not} "interpolated"`, 0)
+	if tok := NewStringLexer(`'unterminated`).NextToken(); tok.typ != itemError {
+		t.Errorf("expected an error for an unterminated string got %s", tok)
+	}
+}
+
+func TestLexSymbolCharacters(t *testing.T) {
+	for _, text := range []string{"_private", "my_key", "héllo", "ключ"} {
+		l := NewStringLexer(text + ": x")
+		assertScalar(t, l.NextToken(), itemSymbol, text, 0)
+		assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	}
+}
+
+func TestLexRawString(t *testing.T) {
+	l := NewStringLexer("re: `^\\d+ \"${x}\" ''`")
+	key, value := keyValue(t, l)
+	assertScalar(t, key, itemSymbol, "re", 0)
+	assertScalar(t, value, itemRawString, `^\d+ "${x}" ''`, 0)
+	if tok := NewStringLexer("`unterminated").NextToken(); tok.typ != itemError {
+		t.Errorf("expected an error for an unterminated string got %s", tok)
+	}
+}
+
+func TestLexMultiLineStringPosition(t *testing.T) {
+	for _, quoted := range []string{`"a\nb"`, `'a\nb'`, "`a\nb`"} {
+		quoted = strings.ReplaceAll(quoted, `\n`, "\n")
+		l := NewStringLexer("x: " + quoted + " + y")
+		l.NextToken()
+		l.NextToken()
+		str := l.NextToken()
+		assertPosition(t, str, "a\nb", 4, 3, 0, 3)
+		if str.end.LineNumber != 1 || str.end.CharacterOffset != 2 {
+			t.Errorf("%s: expected end 1:2 got %d:%d", quoted, str.end.LineNumber, str.end.CharacterOffset)
+		}
+		assertPosition(t, l.NextToken(), "+", 9, 1, 1, 3)
+	}
+}
+
 func TestLexIndent(t *testing.T) {
-	_, items := NewStringLexer("\n  foo: bar")
-	key := <-items
-	colon := <-items
-	value := <-items
+	l := NewStringLexer("\n  foo: bar")
+	key := l.NextToken()
+	colon := l.NextToken()
+	value := l.NextToken()
 	assertScalar(t, key, itemSymbol, "foo", 1)
 	assertScalar(t, colon, itemColon, ":", 1)
 	assertScalar(t, value, itemSymbol, "bar", 1)
-	assertEOF(t, items)
+	assertEOF(t, l)
 }
 
 func TestLexShovel(t *testing.T) {
-	_, items := NewStringLexer("a << b")
-	left := <-items
-	shovel := <-items
-	right := <-items
+	l := NewStringLexer("a << b")
+	left := l.NextToken()
+	shovel := l.NextToken()
+	right := l.NextToken()
 	assertScalar(t, left, itemSymbol, "a", 0)
 	assertScalar(t, shovel, itemShovel, "<<", 0)
 	assertScalar(t, right, itemSymbol, "b", 0)
-	assertEOF(t, items)
+	assertEOF(t, l)
+}
+
+func TestLexMergeKey(t *testing.T) {
+	l := NewStringLexer("<<: a")
+	assertScalar(t, l.NextToken(), itemMergeKey, "<<", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "a", 0)
+	assertEOF(t, l)
 }
 
 func TestLexLet(t *testing.T) {
-	_, items := NewStringLexer("let foo: 1 in foo")
-	assertScalar(t, <-items, itemLet, "let", 0)
-	assertScalar(t, <-items, itemSymbol, "foo", 0)
-	assertScalar(t, <-items, itemColon, ":", 0)
-	assertScalar(t, <-items, itemNumber, "1", 0)
-	assertScalar(t, <-items, itemIn, "in", 0)
-	assertScalar(t, <-items, itemSymbol, "foo", 0)
-	assertEOF(t, items)
+	l := NewStringLexer("let foo: 1 in foo")
+	assertScalar(t, l.NextToken(), itemLet, "let", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "foo", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemNumber, "1", 0)
+	assertScalar(t, l.NextToken(), itemIn, "in", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "foo", 0)
+	assertEOF(t, l)
 }
 
 func TestLexManifest(t *testing.T) {
@@ -208,58 +292,58 @@ spec:
         ports:
         - containerPort: 8080
     `
-	_, items := NewStringLexer(manifest)
-	assertScalar(t, <-items, itemSymbol, "apiVersion", 0)
-	assertScalar(t, <-items, itemColon, ":", 0)
-	assertScalar(t, <-items, itemString, "apps/v1", 0)
-	assertScalar(t, <-items, itemSymbol, "kind", 0)
-	assertScalar(t, <-items, itemColon, ":", 0)
-	assertScalar(t, <-items, itemString, "Deployment", 0)
-	assertScalar(t, <-items, itemSymbol, "metadata", 0)
-	assertScalar(t, <-items, itemColon, ":", 0)
-	assertScalar(t, <-items, itemSymbol, "name", 1)
-	assertScalar(t, <-items, itemColon, ":", 1)
-	assertScalar(t, <-items, itemString, "example-deployment", 1)
-	assertScalar(t, <-items, itemSymbol, "spec", 0)
-	assertScalar(t, <-items, itemColon, ":", 0)
-	assertScalar(t, <-items, itemSymbol, "replicas", 1)
-	assertScalar(t, <-items, itemColon, ":", 1)
-	assertScalar(t, <-items, itemNumber, "3", 1)
-	assertScalar(t, <-items, itemSymbol, "selector", 1)
-	assertScalar(t, <-items, itemColon, ":", 1)
-	assertScalar(t, <-items, itemSymbol, "matchLabels", 2)
-	assertScalar(t, <-items, itemColon, ":", 2)
-	assertScalar(t, <-items, itemSymbol, "app", 3)
-	assertScalar(t, <-items, itemColon, ":", 3)
-	assertScalar(t, <-items, itemString, "example", 3)
-	assertScalar(t, <-items, itemSymbol, "template", 1)
-	assertScalar(t, <-items, itemColon, ":", 1)
-	assertScalar(t, <-items, itemSymbol, "metadata", 2)
-	assertScalar(t, <-items, itemColon, ":", 2)
-	assertScalar(t, <-items, itemSymbol, "labels", 3)
-	assertScalar(t, <-items, itemColon, ":", 3)
-	assertScalar(t, <-items, itemSymbol, "app", 4)
-	assertScalar(t, <-items, itemColon, ":", 4)
-	assertScalar(t, <-items, itemString, "example", 4)
-	assertScalar(t, <-items, itemSymbol, "spec", 2)
-	assertScalar(t, <-items, itemColon, ":", 2)
-	assertScalar(t, <-items, itemSymbol, "containers", 3)
-	assertScalar(t, <-items, itemColon, ":", 3)
-	assertScalar(t, <-items, itemList, "      ", 3) // TODO: this should be "-" or nil
+	l := NewStringLexer(manifest)
+	assertScalar(t, l.NextToken(), itemSymbol, "apiVersion", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemString, "apps/v1", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "kind", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemString, "Deployment", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "metadata", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "name", 1)
+	assertScalar(t, l.NextToken(), itemColon, ":", 1)
+	assertScalar(t, l.NextToken(), itemString, "example-deployment", 1)
+	assertScalar(t, l.NextToken(), itemSymbol, "spec", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "replicas", 1)
+	assertScalar(t, l.NextToken(), itemColon, ":", 1)
+	assertScalar(t, l.NextToken(), itemNumber, "3", 1)
+	assertScalar(t, l.NextToken(), itemSymbol, "selector", 1)
+	assertScalar(t, l.NextToken(), itemColon, ":", 1)
+	assertScalar(t, l.NextToken(), itemSymbol, "matchLabels", 2)
+	assertScalar(t, l.NextToken(), itemColon, ":", 2)
+	assertScalar(t, l.NextToken(), itemSymbol, "app", 3)
+	assertScalar(t, l.NextToken(), itemColon, ":", 3)
+	assertScalar(t, l.NextToken(), itemString, "example", 3)
+	assertScalar(t, l.NextToken(), itemSymbol, "template", 1)
+	assertScalar(t, l.NextToken(), itemColon, ":", 1)
+	assertScalar(t, l.NextToken(), itemSymbol, "metadata", 2)
+	assertScalar(t, l.NextToken(), itemColon, ":", 2)
+	assertScalar(t, l.NextToken(), itemSymbol, "labels", 3)
+	assertScalar(t, l.NextToken(), itemColon, ":", 3)
+	assertScalar(t, l.NextToken(), itemSymbol, "app", 4)
+	assertScalar(t, l.NextToken(), itemColon, ":", 4)
+	assertScalar(t, l.NextToken(), itemString, "example", 4)
+	assertScalar(t, l.NextToken(), itemSymbol, "spec", 2)
+	assertScalar(t, l.NextToken(), itemColon, ":", 2)
+	assertScalar(t, l.NextToken(), itemSymbol, "containers", 3)
+	assertScalar(t, l.NextToken(), itemColon, ":", 3)
+	assertScalar(t, l.NextToken(), itemList, "      ", 3) // TODO: this should be "-" or nil
 	// Indentation following list items should be one level deeper
-	assertScalar(t, <-items, itemSymbol, "name", 4)
-	assertScalar(t, <-items, itemColon, ":", 4)
-	assertScalar(t, <-items, itemString, "example-container", 4)
-	assertScalar(t, <-items, itemSymbol, "image", 4)
-	assertScalar(t, <-items, itemColon, ":", 4)
-	assertScalar(t, <-items, itemString, "example-image", 4)
-	assertScalar(t, <-items, itemSymbol, "ports", 4)
-	assertScalar(t, <-items, itemColon, ":", 4)
-	assertScalar(t, <-items, itemList, "        ", 4) // TODO: this should be "-" or nil
-	assertScalar(t, <-items, itemSymbol, "containerPort", 5)
-	assertScalar(t, <-items, itemColon, ":", 5)
-	assertScalar(t, <-items, itemNumber, "8080", 5)
-	assertEOF(t, items)
+	assertScalar(t, l.NextToken(), itemSymbol, "name", 4)
+	assertScalar(t, l.NextToken(), itemColon, ":", 4)
+	assertScalar(t, l.NextToken(), itemString, "example-container", 4)
+	assertScalar(t, l.NextToken(), itemSymbol, "image", 4)
+	assertScalar(t, l.NextToken(), itemColon, ":", 4)
+	assertScalar(t, l.NextToken(), itemString, "example-image", 4)
+	assertScalar(t, l.NextToken(), itemSymbol, "ports", 4)
+	assertScalar(t, l.NextToken(), itemColon, ":", 4)
+	assertScalar(t, l.NextToken(), itemList, "        ", 4) // TODO: this should be "-" or nil
+	assertScalar(t, l.NextToken(), itemSymbol, "containerPort", 5)
+	assertScalar(t, l.NextToken(), itemColon, ":", 5)
+	assertScalar(t, l.NextToken(), itemNumber, "8080", 5)
+	assertEOF(t, l)
 }
 
 func TestLexPosition(t *testing.T) {
@@ -270,18 +354,327 @@ metadata:
   name: "schön"
     `
 	// Note, with the heredoc - the very first character is a newline
-	_, items := NewStringLexer(manifest)
+	l := NewStringLexer(manifest)
 	// offset, length, line, character offset
-	assertPosition(t, <-items, "apiVersion", 1, 10, 1, 0)
-	assertPosition(t, <-items, ":", 11, 1, 1, 10)
-	assertPosition(t, <-items, "apps/v1", 14, 7, 1, 12)
-	assertPosition(t, <-items, "kind", 23, 4, 2, 0)
-	assertPosition(t, <-items, ":", 27, 1, 2, 4)
-	assertPosition(t, <-items, "Deplöyment", 30, 11, 2, 6)
-	assertPosition(t, <-items, "metadata", 43, 8, 3, 0)
-	assertPosition(t, <-items, ":", 51, 1, 3, 8)
-	assertPosition(t, <-items, "name", 55, 4, 4, 2)
-	assertPosition(t, <-items, ":", 59, 1, 4, 6)
-	assertPosition(t, <-items, "schön", 62, 6, 4, 8)
-	assertEOF(t, items)
+	assertPosition(t, l.NextToken(), "apiVersion", 1, 10, 1, 0)
+	assertPosition(t, l.NextToken(), ":", 11, 1, 1, 10)
+	assertPosition(t, l.NextToken(), "apps/v1", 14, 7, 1, 12)
+	assertPosition(t, l.NextToken(), "kind", 23, 4, 2, 0)
+	assertPosition(t, l.NextToken(), ":", 27, 1, 2, 4)
+	assertPosition(t, l.NextToken(), "Deplöyment", 30, 11, 2, 6)
+	assertPosition(t, l.NextToken(), "metadata", 43, 8, 3, 0)
+	assertPosition(t, l.NextToken(), ":", 51, 1, 3, 8)
+	assertPosition(t, l.NextToken(), "name", 55, 4, 4, 2)
+	assertPosition(t, l.NextToken(), ":", 59, 1, 4, 6)
+	assertPosition(t, l.NextToken(), "schön", 62, 6, 4, 8)
+	assertEOF(t, l)
+}
+
+func TestLexComment(t *testing.T) {
+	l := NewStringLexer("# hello\nfoo: bar # trailing\n   # odd indent\nbaz: 1")
+	comment := l.NextToken()
+	assertScalar(t, comment, itemComment, "# hello", 0)
+	assertPosition(t, comment, "# hello", 0, 7, 0, 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "foo", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "bar", 0)
+	trailing := l.NextToken()
+	assertPosition(t, trailing, "# trailing", 17, 10, 1, 9)
+	assertScalar(t, l.NextToken(), itemComment, "# odd indent", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "baz", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemNumber, "1", 0)
+	assertEOF(t, l)
+}
+
+func TestLexBoolAndNull(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		typ   itemType
+	}{
+		{"true", itemBool},
+		{"True", itemBool},
+		{"FALSE", itemBool},
+		{"null", itemNull},
+		{"NULL", itemNull},
+		{"~", itemNull},
+		{"truthy", itemSymbol},
+		{"nullable", itemSymbol},
+	} {
+		l := NewStringLexer(tc.input)
+		got := single(t, l)
+		assertScalar(t, got, tc.typ, tc.input, 0)
+	}
+}
+
+func TestLexDocumentMarkers(t *testing.T) {
+	l := NewStringLexer("---\nfoo: 1\n...\n---\nbar: ---x")
+	assertPosition(t, l.NextToken(), "---", 0, 3, 0, 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "foo", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemNumber, "1", 0)
+	assertScalar(t, l.NextToken(), itemDocEnd, "...", 0)
+	docStart := l.NextToken()
+	assertScalar(t, docStart, itemDocStart, "---", 0)
+	assertPosition(t, docStart, "---", 15, 3, 3, 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "bar", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	// only a marker at the start of a line separates documents
+	if got := l.NextToken(); got.typ == itemDocStart {
+		t.Errorf("unexpected document marker %v", got)
+	}
+}
+
+func TestLexOperators(t *testing.T) {
+	l := NewStringLexer("a + b - c * d / e % f == g != h < i <= j > k >= l && m || !n")
+	wanted := []itemType{
+		itemSymbol, itemPlus, itemSymbol, itemMinus, itemSymbol, itemStar,
+		itemSymbol, itemSlash, itemSymbol, itemPercent, itemSymbol, itemEqual,
+		itemSymbol, itemNotEqual, itemSymbol, itemLess, itemSymbol, itemLessEqual,
+		itemSymbol, itemGreater, itemSymbol, itemGreaterEqual, itemSymbol, itemAnd,
+		itemSymbol, itemOr, itemBang, itemSymbol,
+	}
+	for _, typ := range wanted {
+		if got := l.NextToken(); got.typ != typ {
+			t.Errorf("got %s, wanted %s", got, typ)
+		}
+	}
+	assertEOF(t, l)
+}
+
+func TestLexOperatorPosition(t *testing.T) {
+	l := NewStringLexer("port: app.port + 1")
+	l.NextToken()
+	l.NextToken()
+	assertPosition(t, l.NextToken(), "app.port", 6, 8, 0, 6)
+	assertPosition(t, l.NextToken(), "+", 15, 1, 0, 15)
+	assertPosition(t, l.NextToken(), "1", 17, 1, 0, 17)
+	assertEOF(t, l)
+}
+
+func TestLexDash(t *testing.T) {
+	l := NewStringLexer("- -5\n- - a\n- --port\nx: a - -1.5")
+	assertScalar(t, l.NextToken(), itemList, "", 0)
+	assertScalar(t, l.NextToken(), itemNumber, "-5", 1)
+	assertScalar(t, l.NextToken(), itemList, "", 0)
+	assertScalar(t, l.NextToken(), itemList, "", 1)
+	assertScalar(t, l.NextToken(), itemSymbol, "a", 2)
+	assertScalar(t, l.NextToken(), itemList, "", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "--port", 1)
+	assertScalar(t, l.NextToken(), itemSymbol, "x", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "a", 0)
+	assertScalar(t, l.NextToken(), itemMinus, "-", 0)
+	assertScalar(t, l.NextToken(), itemNumber, "-1.5", 0)
+	assertEOF(t, l)
+}
+
+func TestLexMinusBeforeName(t *testing.T) {
+	l := NewStringLexer("a: -x\nb: 2 -1\nc: f(-x)\nd: [-c, --port]\n- -c")
+	wanted := []struct {
+		typ itemType
+		val string
+	}{
+		{itemSymbol, "a"}, {itemColon, ":"}, {itemMinus, "-"}, {itemSymbol, "x"},
+		{itemSymbol, "b"}, {itemColon, ":"}, {itemNumber, "2"}, {itemMinus, "-"}, {itemNumber, "1"},
+		{itemSymbol, "c"}, {itemColon, ":"}, {itemSymbol, "f"}, {itemLeftParen, "("}, {itemMinus, "-"}, {itemSymbol, "x"}, {itemRightParen, ")"},
+		{itemSymbol, "d"}, {itemColon, ":"}, {itemLeftBracket, "["}, {itemSymbol, "-c"}, {itemComma, ","}, {itemSymbol, "--port"}, {itemRightBracket, "]"},
+		{itemList, ""}, {itemSymbol, "-c"},
+	}
+	for _, w := range wanted {
+		if got := l.NextToken(); got.typ != w.typ || got.val != w.val {
+			t.Errorf("got %s %q, wanted %s %q", got.typ, got.val, w.typ, w.val)
+		}
+	}
+	assertEOF(t, l)
+}
+
+func TestLexUnexpectedCharacter(t *testing.T) {
+	l := NewStringLexer("foo: @")
+	l.NextToken()
+	l.NextToken()
+	got := l.NextToken()
+	if got.typ != itemError {
+		t.Fatalf("expected error, got %v", got)
+	}
+}
+
+func TestLexStringInterpolation(t *testing.T) {
+	// quotes inside an interpolation don't terminate the string
+	l := NewStringLexer(`name: "${join("-", parts)}-svc" # done`)
+	l.NextToken()
+	l.NextToken()
+	assertPosition(t, l.NextToken(), `${join("-", parts)}-svc`, 7, 23, 0, 6)
+	assertScalar(t, l.NextToken(), itemComment, "# done", 0)
+	assertEOF(t, l)
+}
+
+func TestLexUnterminatedInterpolation(t *testing.T) {
+	l := NewStringLexer(`name: "${app.name`)
+	l.NextToken()
+	l.NextToken()
+	got := l.NextToken()
+	if got.typ != itemError || got.val != "EOF reached in unterminated interpolation" {
+		t.Errorf("expected unterminated interpolation error, got %v", got)
+	}
+}
+
+func TestLexFragmentOrigin(t *testing.T) {
+	l := newFragmentLexer("a + b", Position{ByteOffset: 20, LineNumber: 3, CharacterOffset: 9})
+	assertPosition(t, l.NextToken(), "a", 20, 1, 3, 9)
+	assertPosition(t, l.NextToken(), "+", 22, 1, 3, 11)
+	assertPosition(t, l.NextToken(), "b", 24, 1, 3, 13)
+	assertEOF(t, l)
+}
+
+func TestLexBlockScalar(t *testing.T) {
+	l := NewStringLexer("a: |-\n  one\n\n    two\nb: >\n  x\n")
+	assertScalar(t, l.NextToken(), itemSymbol, "a", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertPosition(t, l.NextToken(), "|-\n  one\n\n    two", 3, 17, 0, 3)
+	assertPosition(t, l.NextToken(), "b", 21, 1, 4, 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertPosition(t, l.NextToken(), ">\n  x", 24, 5, 4, 3)
+	assertEOF(t, l)
+}
+
+func TestLexBlockScalarIndentation(t *testing.T) {
+	// content must be indented further than the line holding the header
+	l := NewStringLexer("spec:\n  script: | # run me\n    echo\n  # note\n  next: 1")
+	assertScalar(t, l.NextToken(), itemSymbol, "spec", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "script", 1)
+	assertScalar(t, l.NextToken(), itemColon, ":", 1)
+	assertScalar(t, l.NextToken(), itemBlockString, "| # run me\n    echo", 1)
+	assertPosition(t, l.NextToken(), "# note", 38, 6, 3, 2)
+	assertScalar(t, l.NextToken(), itemSymbol, "next", 1)
+	assertScalar(t, l.NextToken(), itemColon, ":", 1)
+	assertScalar(t, l.NextToken(), itemNumber, "1", 1)
+	assertEOF(t, l)
+}
+
+func TestLexGreaterIsNotBlockScalar(t *testing.T) {
+	l := NewStringLexer("a > b")
+	assertScalar(t, l.NextToken(), itemSymbol, "a", 0)
+	assertScalar(t, l.NextToken(), itemGreater, ">", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "b", 0)
+	assertEOF(t, l)
+}
+
+func TestLexFlowCollections(t *testing.T) {
+	l := NewStringLexer("a: {b: [1, x]}")
+	assertScalar(t, l.NextToken(), itemSymbol, "a", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertPosition(t, l.NextToken(), "{", 3, 1, 0, 3)
+	assertScalar(t, l.NextToken(), itemSymbol, "b", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertPosition(t, l.NextToken(), "[", 7, 1, 0, 7)
+	assertScalar(t, l.NextToken(), itemNumber, "1", 0)
+	assertPosition(t, l.NextToken(), ",", 9, 1, 0, 9)
+	assertScalar(t, l.NextToken(), itemSymbol, "x", 0)
+	assertScalar(t, l.NextToken(), itemRightBracket, "]", 0)
+	assertPosition(t, l.NextToken(), "}", 13, 1, 0, 13)
+	assertEOF(t, l)
+}
+
+func TestLexFlowIgnoresIndentation(t *testing.T) {
+	// line breaks inside a flow collection don't take part in
+	// indentation, tokens keep the indent of the opening line
+	l := NewStringLexer("x:\n  args: [\n   -1,\n - b\n]\nc: 1")
+	assertScalar(t, l.NextToken(), itemSymbol, "x", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "args", 1)
+	assertScalar(t, l.NextToken(), itemColon, ":", 1)
+	assertScalar(t, l.NextToken(), itemLeftBracket, "[", 1)
+	assertPosition(t, l.NextToken(), "-1", 16, 2, 2, 3)
+	assertScalar(t, l.NextToken(), itemComma, ",", 1)
+	assertScalar(t, l.NextToken(), itemMinus, "-", 1)
+	assertScalar(t, l.NextToken(), itemSymbol, "b", 1)
+	assertPosition(t, l.NextToken(), "]", 25, 1, 4, 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "c", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	assertScalar(t, l.NextToken(), itemNumber, "1", 0)
+	assertEOF(t, l)
+}
+
+func TestLexEOFPosition(t *testing.T) {
+	l := NewStringLexer("x: ab")
+	l.NextToken()
+	l.NextToken()
+	l.NextToken()
+	assertPosition(t, l.NextToken(), "", 5, 0, 0, 5)
+}
+
+func TestLexConditional(t *testing.T) {
+	l := NewStringLexer("if a then 1 else iffy")
+	assertScalar(t, l.NextToken(), itemIf, "if", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "a", 0)
+	assertScalar(t, l.NextToken(), itemThen, "then", 0)
+	assertScalar(t, l.NextToken(), itemNumber, "1", 0)
+	assertScalar(t, l.NextToken(), itemElse, "else", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "iffy", 0)
+	assertEOF(t, l)
+}
+
+func TestLexAnchorAndAlias(t *testing.T) {
+	l := NewStringLexer("a: &base-1 x\nb: *base-1 & 2")
+	assertScalar(t, l.NextToken(), itemSymbol, "a", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	anchor := l.NextToken()
+	assertScalar(t, anchor, itemAnchor, "&base-1", 0)
+	assertPosition(t, anchor, "&base-1", 3, 7, 0, 3)
+	assertScalar(t, l.NextToken(), itemSymbol, "x", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "b", 0)
+	assertScalar(t, l.NextToken(), itemColon, ":", 0)
+	// aliases are a star and a symbol, the parser puts them together
+	assertScalar(t, l.NextToken(), itemStar, "*", 0)
+	assertScalar(t, l.NextToken(), itemSymbol, "base-1", 0)
+	if tok := l.NextToken(); tok.typ != itemError {
+		t.Errorf("expected an error for a lone '&' got %s", tok)
+	}
+}
+
+// benchmarkInput is a stream of manifests, the size of a modest
+// workspace
+func benchmarkInput() string {
+	var sb strings.Builder
+	for i := 0; i < 200; i++ {
+		if i > 0 {
+			sb.WriteString("---\n")
+		}
+		fmt.Fprintf(&sb, `# deployment %[1]d
+apiVersion: "apps/v1"
+kind: "Deployment"
+metadata:
+  name: "app-%[1]d"
+  labels: {app: web, tier: "${tier}"}
+spec:
+  replicas: %[1]d
+  selector:
+    matchLabels:
+      app: "app-%[1]d"
+  template:
+    spec:
+      containers:
+        - name: "app"
+          image: "registry.example.com/app:v1.2.%[1]d"
+          args: [--port, 8080, --verbose]
+          ports:
+            - containerPort: 8080
+          resources:
+            limits: {cpu: 1, memory: "512Mi"}
+`, i)
+	}
+	return sb.String()
+}
+
+func BenchmarkLex(b *testing.B) {
+	input := benchmarkInput()
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := NewStringLexer(input)
+		for l.NextToken().typ != itemEOF {
+		}
+	}
 }
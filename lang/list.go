@@ -1,7 +1,19 @@
 package lang
 
 // List represents a YAML list/sequence.
-type List []node
+type List []*ListItem
+
+// ListItem is a single entry of a List. Doc holds the
+// comment group immediately preceding the item, if any.
+type ListItem struct {
+	Position Position // the '-' of a block item, the value of a flow one
+	Doc      *CommentGroup
+	Value    node
+}
+
+func (i *ListItem) Pos() Position { return i.Position }
+
+func (i *ListItem) End() Position { return i.Value.End() }
 
 func (l *List) Pos() Position {
 	if l == nil || len(*l) == 0 {
@@ -17,9 +29,22 @@ func (l *List) Pos() Position {
 	return pos
 }
 
+// End is the end of the last item. As with maps, the span of a
+// flow list with its brackets is its Flow's.
+func (l *List) End() Position {
+	if l == nil || len(*l) == 0 {
+		return Position{}
+	}
+	return (*l)[len(*l)-1].End()
+}
+
 func (l *List) Symbols() []node {
 	if l == nil {
 		return nil
 	}
-	return *l
+	values := make([]node, 0, len(*l))
+	for _, item := range *l {
+		values = append(values, item.Value)
+	}
+	return values
 }
@@ -10,6 +10,9 @@ type NostosError interface {
 	URI() uri.URI
 	// Pos returns the position associated with the error.
 	Pos() Position
+	// End returns the position just past the source text the
+	// error applies to. It equals Pos when there's no such text.
+	End() Position
 	// StackTrace returns a stack trace for runtime errors. Parsing or lexing
 	// errors may return nil.
 	StackTrace() []string
@@ -0,0 +1,10 @@
+package lang
+
+// Null is the null literal, spelled `null` or `~`.
+type Null struct {
+	Position Position
+}
+
+func (n *Null) Pos() Position { return n.Position }
+
+func (n *Null) End() Position { return asciiEnd(n.Position) }
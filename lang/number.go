@@ -6,3 +6,5 @@ type Number struct {
 }
 
 func (n *Number) Pos() Position { return n.Position }
+
+func (n *Number) End() Position { return asciiEnd(n.Position) }
@@ -0,0 +1,29 @@
+package lang
+
+// BinaryOp is an infix operator expression such as `a + b`
+// or `env == "prod"`. Operator holds the operator's spelling.
+type BinaryOp struct {
+	Operator    string
+	OperatorPos Position
+	Left        node
+	Right       node
+}
+
+func (b *BinaryOp) Pos() Position { return b.Left.Pos() }
+
+func (b *BinaryOp) End() Position { return b.Right.End() }
+
+func (b *BinaryOp) leftExpr() node { return b.Left }
+
+func (b *BinaryOp) rightExpr() node { return b.Right }
+
+// UnaryOp is a prefix operator expression, `-x` or `!x`.
+type UnaryOp struct {
+	Position Position // position of the operator
+	Operator string
+	Operand  node
+}
+
+func (u *UnaryOp) Pos() Position { return u.Position }
+
+func (u *UnaryOp) End() Position { return u.Operand.End() }
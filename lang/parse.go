@@ -31,9 +31,10 @@ package lang
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
-	"time"
+	"unicode/utf8"
 
 	"go.lsp.dev/uri"
 
@@ -42,15 +43,39 @@ import (
 
 type parser struct {
 	current       *item
+	previous      *item // the token before current
 	peeked        *item
+	ahead         []*item // tokens read past peeked, see lookahead
 	currentIndent uint
 	priorIndent   uint
 	priorNode     node
-	tokens        <-chan item
+	tokens        *lexer
 	uri           uri.URI
+
+	// Comments are trivia to the grammar, peek() strips them
+	// from the token stream and groups them here instead
+	comments    []*CommentGroup        // all comment groups in source order
+	leadComment *CommentGroup          // doc comment for the current token
+	peekedDoc   *CommentGroup          // doc comment for the peeked token
+	docs        map[uint]*CommentGroup // doc comments by the byte offset of their token
+	lastLine    uint                   // line of the last non-comment token
+	sawToken    bool
+
+	// YAML anchors of the current document become let bindings
+	// wrapped around it, see _anchor
+	anchors       Map
+	anchorBinding map[string]string // anchor name to its current binding
+
+	// colonEnds is set in the header of a comprehension, where a
+	// ':' ends an expression rather than making it a map key
+	colonEnds bool
+
+	// awaiting holds the keywords that continue the enclosing
+	// lets and ifs, `in`, `then` and `else`, see synchronize
+	awaiting []itemType
 }
 
-func NewParser(tokens <-chan item, u uri.URI) *parser {
+func NewParser(tokens *lexer, u uri.URI) *parser {
 	return &parser{
 		tokens: tokens,
 		uri:    u,
@@ -61,6 +86,8 @@ type Precedence int
 
 const (
 	precedenceLowest Precedence = iota
+	precedenceOr
+	precedenceAnd
 	precedenceEquality
 	precedenceLessGreater
 	precedenceSum
@@ -71,7 +98,7 @@ const (
 
 type node interface {
 	Pos() Position // position of first character belonging to the node
-	// End() Position // position of first character immediately after the node
+	End() Position // position of first character immediately after the node
 }
 
 type binaryOpNode interface {
@@ -105,11 +132,12 @@ type ParseError struct {
 
 func (e *ParseError) Pos() Position { return e.Token.position }
 
+func (e *ParseError) End() Position { return e.Token.end }
+
 func (e *ParseError) URI() uri.URI { return e.File }
 
 func (e *ParseError) StackTrace() []string { return nil }
 
-// func (e *ParseError) End() pos { return pos(e.Token.position.ByteOffset + e.Token.position.ByteLength) }
 func (e *ParseError) Error() string {
 	line := e.Token.position.LineNumber
 	offset := e.Token.position.CharacterOffset
@@ -135,7 +163,7 @@ type Comment struct {
 
 func (c *Comment) Pos() Position { return c.Hash }
 
-// func (c *Comment) End() pos { return pos(int(c.Hash) + len(c.Text)) }
+func (c *Comment) End() Position { return endOf(c.Hash, c.Text) }
 
 // A CommentGroup represents a sequence of comments with
 // no other tokens and no empty lines between.
@@ -145,7 +173,9 @@ type CommentGroup struct {
 
 func (g *CommentGroup) Pos() Position { return g.List[0].Pos() }
 
-// func (g *CommentGroup) End() pos { return g.List[len(g.List)-1].End() }
+func (g *CommentGroup) End() Position { return g.List[len(g.List)-1].End() }
+
+func (g *CommentGroup) endLine() uint { return g.List[len(g.List)-1].Hash.LineNumber }
 
 func isWhitespace(ch byte) bool { return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' }
 
@@ -212,46 +242,83 @@ func (g *CommentGroup) Text() string {
 
 // -----------------------------------------------------
 // Strings
+//
+// Text is the value of the string, so EndPosition is kept
+// apart since the source may be longer: quotes, escapes
+// and block scalar indentation.
 type String struct {
-	Position Position
-	Text     string
+	Position    Position
+	Text        string
+	EndPosition Position
 }
 
 func (s *String) Pos() Position { return s.Position }
 
-// func (s *String) End() pos { return pos(int(s.Position) + len(s.Text)) }
+func (s *String) End() Position { return s.EndPosition }
 
 // -----------------------------------------------------
 // Symbol
+//
+// Doc is the comment group on the line(s) immediately
+// preceding the symbol. It's how map keys and let
+// bindings carry their documentation.
 type Symbol struct {
 	Position Position
 	Text     string
+	Doc      *CommentGroup
 }
 
 func (s *Symbol) Pos() Position { return s.Position }
 
+// End is computed from the length of the symbol's source, every
+// symbol is on a single line. Keys the parser spells differently
+// than the source, like `~:` becoming null, are ASCII.
+func (s *Symbol) End() Position {
+	chars := s.Position.ByteLength
+	if uint(len(s.Text)) == s.Position.ByteLength {
+		chars = uint(utf8.RuneCountInString(s.Text))
+	}
+	return Position{
+		ByteOffset:      s.Position.ByteOffset + s.Position.ByteLength,
+		LineNumber:      s.Position.LineNumber,
+		CharacterOffset: s.Position.CharacterOffset + chars,
+	}
+}
+
 // -----------------------------------------------------
 // Path literal parsing is defined in path.go
 
 // -----------------------------------------------------
-// Map - dictionary/hash/associative array
-type Map map[Symbol]node
+// Map - dictionary/hash/associative array. Entries are kept
+// in the order they're written, which is the order they're
+// evaluated and rendered in.
+type Map []*MapEntry
+
+// MapEntry is a single key/value pair of a Map. In let bindings
+// the key may be a Pattern destructuring the value, Key then
+// holds the pattern's text.
+type MapEntry struct {
+	Key     Symbol
+	Value   node
+	Pattern *Pattern
+}
 
-// These functions have really terrible O(...) performance
-func (m *Map) Pos() Position {
-	var (
-		pos   Position
-		first = true
-	)
-	for symbol := range *m {
-		sPos := symbol.Pos()
-		if first || sPos.Less(pos) {
-			pos = sPos
-			first = false
+// Get returns the value of the last entry named key, the one
+// evaluation keeps, or nil if there isn't one.
+func (m *Map) Get(key string) node {
+	for i := len(*m) - 1; i >= 0; i-- {
+		if (*m)[i].Key.Text == key {
+			return (*m)[i].Value
 		}
 	}
-	return pos
+	return nil
+}
 
+func (m *Map) Pos() Position {
+	if m == nil || len(*m) == 0 {
+		return Position{}
+	}
+	return (*m)[0].Key.Pos()
 }
 
 // TODO: we're cheating here, this is
@@ -259,22 +326,26 @@ func (m *Map) Pos() Position {
 // might be key/value or just value.  We're looking
 // for the symbols not the value nodes
 func (m *Map) Symbols() []node {
-	symbols := make([]node, 0)
-	for key := range *m {
-		symbols = append(symbols, &key)
+	symbols := make([]node, 0, len(*m))
+	for _, entry := range *m {
+		symbols = append(symbols, &entry.Key)
 	}
 	return symbols
 }
 
-// func (m *Map) End() pos {
-// 	var pos pos = math.MaxInt32
-// 	for _, value := range *m {
-// 		if pos < value.End() {
-// 			pos = value.End()
-// 		}
-// 	}
-// 	return pos
-// }
+// End is the end of the last entry. A map has no position of its
+// own, the span of a flow map with its braces is its Flow's.
+func (m *Map) End() Position {
+	if m == nil || len(*m) == 0 {
+		return Position{}
+	}
+	last := (*m)[len(*m)-1]
+	end := last.Key.End()
+	if e := last.Value.End(); end.Less(e) {
+		end = e
+	}
+	return end
+}
 
 type parseFn func(*parser) node
 type infixFn func(*parser, node) node
@@ -294,16 +365,46 @@ func init() {
 	tokenMap[itemError] = tokenMapping{precedenceCall, lexError, leftDenotationUnhandled}
 	tokenMap[itemColon] = tokenMapping{precedenceCall, nullDenotationUnhandled, _map}
 	tokenMap[itemArrow] = tokenMapping{precedenceCall, nullDenotationUnhandled, _function}
-	tokenMap[itemShovel] = tokenMapping{precedenceCall, nullDenotationUnhandled, _shovel}
-	tokenMap[itemLeftParen] = tokenMapping{precedenceCall, nullDenotationUnhandled, _call}
+	tokenMap[itemShovel] = tokenMapping{precedenceCall, _leadingShovel, _shovel}
+	tokenMap[itemMergeKey] = tokenMapping{precedenceLowest, symbol, leftDenotationUnhandled}
+	tokenMap[itemLeftParen] = tokenMapping{precedenceCall, _group, _call}
 	tokenMap[itemRightParen] = tokenMapping{precedenceLowest, nullDenotationUnhandled, leftDenotationUnhandled}
 	tokenMap[itemList] = tokenMapping{precedenceLowest, _list, leftDenotationUnhandled}
 	tokenMap[itemNumber] = tokenMapping{precedenceLowest, _number, leftDenotationUnhandled}
 	tokenMap[itemString] = tokenMapping{precedenceLowest, _string, leftDenotationUnhandled}
+	tokenMap[itemRawString] = tokenMapping{precedenceLowest, _rawString, leftDenotationUnhandled}
+	tokenMap[itemBlockString] = tokenMapping{precedenceLowest, _blockString, leftDenotationUnhandled}
+	tokenMap[itemLeftBrace] = tokenMapping{precedenceLowest, _flowMap, leftDenotationUnhandled}
+	tokenMap[itemRightBrace] = tokenMapping{precedenceLowest, nullDenotationUnhandled, leftDenotationUnhandled}
+	tokenMap[itemLeftBracket] = tokenMapping{precedenceCall, _flowList, _index}
+	tokenMap[itemDot] = tokenMapping{precedenceCall, nullDenotationUnhandled, _select}
+	tokenMap[itemRightBracket] = tokenMapping{precedenceLowest, nullDenotationUnhandled, leftDenotationUnhandled}
+	tokenMap[itemComma] = tokenMapping{precedenceLowest, nullDenotationUnhandled, leftDenotationUnhandled}
 	tokenMap[itemPath] = tokenMapping{precedenceLowest, _path, leftDenotationUnhandled}
 	tokenMap[itemSymbol] = tokenMapping{precedenceLowest, symbol, leftDenotationUnhandled}
 	tokenMap[itemLet] = tokenMapping{precedenceLowest, _let, leftDenotationUnhandled}
 	tokenMap[itemIn] = tokenMapping{precedenceLowest, nullDenotationUnhandled, leftDenotationUnhandled}
+	tokenMap[itemIf] = tokenMapping{precedenceLowest, _if, leftDenotationUnhandled}
+	tokenMap[itemThen] = tokenMapping{precedenceLowest, nullDenotationUnhandled, leftDenotationUnhandled}
+	tokenMap[itemElse] = tokenMapping{precedenceLowest, nullDenotationUnhandled, leftDenotationUnhandled}
+	tokenMap[itemBool] = tokenMapping{precedenceLowest, _bool, leftDenotationUnhandled}
+	tokenMap[itemNull] = tokenMapping{precedenceLowest, _null, leftDenotationUnhandled}
+	tokenMap[itemOr] = tokenMapping{precedenceOr, nullDenotationUnhandled, _binary}
+	tokenMap[itemAnd] = tokenMapping{precedenceAnd, nullDenotationUnhandled, _binary}
+	tokenMap[itemEqual] = tokenMapping{precedenceEquality, nullDenotationUnhandled, _binary}
+	tokenMap[itemNotEqual] = tokenMapping{precedenceEquality, nullDenotationUnhandled, _binary}
+	tokenMap[itemLess] = tokenMapping{precedenceLessGreater, nullDenotationUnhandled, _binary}
+	tokenMap[itemLessEqual] = tokenMapping{precedenceLessGreater, nullDenotationUnhandled, _binary}
+	tokenMap[itemGreater] = tokenMapping{precedenceLessGreater, nullDenotationUnhandled, _binary}
+	tokenMap[itemGreaterEqual] = tokenMapping{precedenceLessGreater, nullDenotationUnhandled, _binary}
+	tokenMap[itemPlus] = tokenMapping{precedenceSum, nullDenotationUnhandled, _binary}
+	tokenMap[itemMinus] = tokenMapping{precedenceSum, _unary, _binary}
+	tokenMap[itemStar] = tokenMapping{precedenceProduct, _alias, _binary}
+	tokenMap[itemSlash] = tokenMapping{precedenceProduct, nullDenotationUnhandled, _binary}
+	tokenMap[itemPercent] = tokenMapping{precedenceProduct, nullDenotationUnhandled, _binary}
+	tokenMap[itemBang] = tokenMapping{precedenceLowest, _unary, leftDenotationUnhandled}
+	tokenMap[itemAnchor] = tokenMapping{precedenceLowest, _anchor, leftDenotationUnhandled}
+	tokenMap[itemAssign] = tokenMapping{precedenceLowest, nullDenotationUnhandled, leftDenotationUnhandled}
 }
 
 func (p *parser) _error(message string) node {
@@ -322,42 +423,119 @@ func lexError(p *parser) node {
 	return p._error(p.current.val)
 }
 
+// Parse returns the root node of the token stream. A stream
+// holding several `---` separated documents yields *Documents.
+// Syntax errors don't stop the parse, they're left in the tree
+// as ParseError nodes, see CollectParseErrors.
 func (p *parser) Parse() node {
-	var root node
-	for !p.isEOF() {
+	var docs Documents
+	for {
+		root := p.parseDocument()
+		if root != nil {
+			docs = append(docs, root)
+		}
+		if !p.isDocumentBoundary() {
+			break
+		}
+		p.accept()
+		// documents are independent of one another
+		p.priorNode = nil
+		p.priorIndent = 0
+	}
+	switch len(docs) {
+	case 0:
+		return nil
+	case 1:
+		return docs[0]
+	default:
+		return &docs
+	}
+}
+
+func (p *parser) parseDocument() node {
+	var (
+		root node
+		errs []node // errors the root has no place for
+	)
+	// anchors are scoped to the document they're in
+	p.anchors = nil
+	p.anchorBinding = make(map[string]string)
+	for !p.isEOF() && !p.isDocumentBoundary() {
+		first := p.peek()
 		res := p.parseExpression(precedenceLowest)
+		if _, ok := res.(errorNode); ok {
+			errs = append(errs, res)
+			p.synchronize(0)
+			// keys after the error still belong to the root
+			p.priorNode = root
+			p.priorIndent = 0
+			continue
+		}
 		// we only loop to manage sibling leafs
 		// e.g.
 		//   foo: "first loop"
 		//   bar: "second loop"
-		// this is true for maps and arrays
-		// both iterations should yield the same node, if they
-		// aren't then this would be a statement which is always
-		// an error
-		// TODO: this check doesn't actually work
-		// if root != nil && res != root {
-		// 	log.Fatalf("Parse error: document must be a single expression\n\tcurrent token: %v\n\tnext token: %v\n\troot: %v\n\tres:  %v\n", self.current, self.peek(), root, res)
-		// }
-		if rootMap, ok := root.(*Map); ok {
-			if resMap, ok := res.(*Map); ok {
-				for k, v := range *resMap {
-					(*rootMap)[k] = v
-				}
-				root = rootMap
-			} else {
-				root = res
-			}
-		} else if root == nil {
+		// this is true for maps and arrays, both iterations
+		// should yield the same node. Anything else is a second
+		// expression, which a document can't hold
+		rootMap, rootIsMap := root.(*Map)
+		resMap, resIsMap := res.(*Map)
+		switch {
+		case root == nil || res == root:
 			root = res
-		} else {
-			root = res
-		}
-		if err, ok := root.(errorNode); ok {
-			return err
+		case rootIsMap && resIsMap:
+			*rootMap = append(*rootMap, *resMap...)
+		default:
+			errs = append(errs, &ParseError{File: p.uri, Message: "unexpected expression after the document's value", Token: first})
 		}
+	}
+	if root != nil && len(p.anchors) > 0 {
+		anchors := p.anchors
+		root = &Let{Position: root.Pos(), Bindings: &anchors, Body: root}
+	}
+	switch {
+	case len(errs) == 0:
+		return root
+	case root == nil && len(errs) == 1:
+		return errs[0]
+	default:
+		return &Invalid{Root: root, Errors: errs}
+	}
+}
 
+// synchronize recovers from a syntax error by skipping to the
+// first token of a later line indented no deeper than indent,
+// which is where the next sibling of the broken expression
+// would start. It stops early at the end of the document, and at
+// a keyword continuing an enclosing let or if, so that the rest of
+// it parses, `let y: ) in y`.
+func (p *parser) synchronize(indent uint) {
+	line := p.current.position.LineNumber
+	for {
+		tok := p.peek()
+		switch {
+		case tok.typ == itemEOF || tok.typ == itemDocStart || tok.typ == itemDocEnd:
+			return
+		case slices.Contains(p.awaiting, tok.typ):
+			return
+		case tok.position.LineNumber > line && tok.indent <= indent:
+			return
+		}
+		p.accept()
 	}
-	return root
+}
+
+// await notes that keyword continues the expression being parsed
+// until the returned function is called
+func (p *parser) await(keyword itemType) func() {
+	p.awaiting = append(p.awaiting, keyword)
+	n := len(p.awaiting)
+	return func() { p.awaiting = p.awaiting[:n-1] }
+}
+
+func (p *parser) isDocumentBoundary() bool {
+	typ := p.peek().typ
+	return typ == itemDocStart || typ == itemDocEnd
 }
 
 func (p *parser) isEOF() bool {
@@ -367,37 +545,102 @@ func (p *parser) isEOF() bool {
 func (p *parser) peek() *item {
 	if p.peeked != nil {
 		return p.peeked
-	} else {
-		select {
-		case tok, ok := <-p.tokens:
-			if !ok {
-				eof := &item{typ: itemEOF}
-				p.peeked = eof
-				return eof
-
+	}
+	var (
+		group       *CommentGroup
+		lineComment bool
+	)
+	for {
+		tok := p.receive()
+		if tok.typ != itemComment {
+			// a group is a lead comment when it ends on the line
+			// immediately before the token it documents
+			p.peekedDoc = nil
+			if group != nil && !lineComment && group.endLine()+1 == tok.position.LineNumber {
+				p.peekedDoc = group
 			}
-			// fmt.Printf("-> %v\n", tok)
-			p.peeked = &tok
-			return &tok
-		case <-time.After(2 * time.Second):
-			err := &item{typ: itemError, val: "lexer timeout"}
-			p.peeked = err
-			return err
+			p.lastLine = tok.position.LineNumber
+			p.sawToken = true
+			p.peeked = tok
+			return tok
+		}
+		comment := &Comment{Hash: tok.position, Text: tok.val}
+		line := comment.Hash.LineNumber
+		// a comment sharing a line with a prior token trails that
+		// token, so it starts a group of its own and is never a doc
+		trailing := p.sawToken && line == p.lastLine
+		if group == nil || trailing || lineComment || group.endLine()+1 != line {
+			group = &CommentGroup{}
+			lineComment = trailing
+			p.comments = append(p.comments, group)
+		}
+		group.List = append(group.List, comment)
+	}
+}
+
+// lookahead returns the nth token after the peeked one, skipping
+// comments, without consuming anything
+func (p *parser) lookahead(n int) *item {
+	p.peek()
+	for i := 0; ; i++ {
+		if i == len(p.ahead) {
+			tok := p.tokens.NextToken()
+			p.ahead = append(p.ahead, &tok)
 		}
+		tok := p.ahead[i]
+		if tok.typ == itemComment {
+			continue
+		}
+		if n--; n == 0 || tok.typ == itemEOF {
+			return tok
+		}
+	}
+}
+
+func (p *parser) receive() *item {
+	if len(p.ahead) > 0 {
+		tok := p.ahead[0]
+		p.ahead = p.ahead[1:]
+		return tok
 	}
+	tok := p.tokens.NextToken()
+	// fmt.Printf("-> %v\n", tok)
+	return &tok
+}
+
+// Comments returns every comment group seen by the parser so far
+func (p *parser) Comments() []*CommentGroup {
+	return p.comments
 }
 
 func (p *parser) accept() *item {
 	peeked := p.peek()
 	p.peeked = nil
+	p.previous = p.current
 	p.current = peeked
 	p.currentIndent = peeked.indent
+	p.leadComment = p.peekedDoc
+	if p.peekedDoc != nil {
+		if p.docs == nil {
+			p.docs = make(map[uint]*CommentGroup)
+		}
+		p.docs[peeked.position.ByteOffset] = p.peekedDoc
+	}
+	p.peekedDoc = nil
 	// fmt.Printf("accept: parse.current: %v\n", peeked)
 	return peeked
 }
 
 func (p *parser) peekPrecedence() Precedence {
 	token := p.peek()
+	// xs[0] is an index, while a bracket set apart from
+	// the expression before it begins a flow list
+	if token.typ == itemLeftBracket && !p.adjacent(token) {
+		return precedenceLowest
+	}
+	if token.typ == itemColon && p.colonEnds {
+		return precedenceLowest
+	}
 	mapping, ok := tokenMap[token.typ]
 	if !ok {
 		return -1 // we've probably hit EOF
@@ -405,6 +648,15 @@ func (p *parser) peekPrecedence() Precedence {
 	return mapping.Precedence
 }
 
+// adjacent reports whether tok immediately follows the current token
+func (p *parser) adjacent(tok *item) bool {
+	cur := p.current
+	if cur == nil || cur.position.LineNumber != tok.position.LineNumber {
+		return false
+	}
+	return cur.position.ByteOffset+cur.position.ByteLength == tok.position.ByteOffset
+}
+
 func (p *parser) parseExpression(precedence Precedence) node {
 	token := p.accept()
 	if token == nil {
@@ -441,13 +693,274 @@ func (p *parser) parseExpression(precedence Precedence) node {
 	return lhs
 }
 
+// _rawString is a single quoted string, taken as written
+func _rawString(p *parser) node {
+	return &String{p.current.position, p.current.val, p.current.end}
+}
+
 func _string(p *parser) node {
-	return &String{p.current.position, p.current.val}
+	tok := p.current
+	if !strings.Contains(tok.val, "${") && !strings.Contains(tok.val, "\\") {
+		return &String{tok.position, tok.val, tok.end}
+	}
+	// the token's byte offset is at the string contents
+	// while its character offset is at the opening quote
+	pos := tok.position
+	pos.ByteLength = 0
+	pos.CharacterOffset++
+	parts, err := p.interpolate(nil, tok.val, pos, true)
+	if err != nil {
+		return err
+	}
+	return stringNode(tok.position, tok.end, parts)
+}
+
+// interpolate splits s, found in the document at pos, into its
+// literal segments and ${...} expressions and appends them to
+// parts. "\${" is a literal "${". Backslash escapes are decoded
+// when s is quoted, block scalars keep them as written.
+func (p *parser) interpolate(parts []node, s string, pos Position, quoted bool) ([]node, node) {
+	var (
+		literal    strings.Builder
+		literalPos = pos
+		start      = 0 // start of the input not yet accounted for in pos
+	)
+	// flush ends the literal segment at s[i]
+	flush := func(i int) {
+		if literal.Len() > 0 {
+			parts = append(parts, &String{literalPos, literal.String(), endOf(pos, s[start:i])})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "\\${"):
+			if literal.Len() == 0 {
+				literalPos = advance(pos, s[start:i])
+			}
+			literal.WriteString("${")
+			i += 3
+		case strings.HasPrefix(s[i:], "${"):
+			flush(i)
+			exprPos := advance(pos, s[start:i])
+			end := skipInterpolation(s, i+2)
+			if end == len(s) {
+				exprPos.ByteLength = uint(len(s) - i)
+				return nil, &ParseError{File: p.uri, Message: "unterminated interpolation", Token: &item{itemString, s[i:], exprPos, endOf(exprPos, s[i:]), p.current.indent}}
+			}
+			src := s[i+2 : end]
+			if strings.TrimSpace(src) == "" {
+				exprPos.ByteLength = uint(end + 1 - i)
+				return nil, &ParseError{File: p.uri, Message: "empty interpolation", Token: &item{itemString, s[i : end+1], exprPos, endOf(exprPos, s[i:end+1]), p.current.indent}}
+			}
+			exprPos.ByteLength = uint(end + 1 - i)
+			expr := p.parseEmbedded(s[i:end+1], exprPos)
+			if err, ok := expr.(errorNode); ok {
+				return nil, err
+			}
+			parts = append(parts, expr)
+			pos = advance(pos, s[start:end+1])
+			i = end + 1
+			start = i
+		case quoted && s[i] == '\\':
+			n, r, msg := unescape(s[i:])
+			if msg != "" {
+				escPos := advance(pos, s[start:i])
+				escPos.ByteLength = uint(n)
+				return nil, &ParseError{File: p.uri, Message: msg, Token: &item{itemString, s[i : i+n], escPos, endOf(escPos, s[i:i+n]), p.current.indent}}
+			}
+			if literal.Len() == 0 {
+				literalPos = advance(pos, s[start:i])
+			}
+			literal.WriteRune(r)
+			i += n
+		default:
+			if literal.Len() == 0 {
+				literalPos = advance(pos, s[start:i])
+			}
+			literal.WriteByte(s[i])
+			i++
+		}
+	}
+	flush(len(s))
+	return parts, nil
+}
+
+// unescape decodes the backslash escape at the start of s, returning
+// its length in s and the rune it stands for, or a message saying
+// why it's invalid
+func unescape(s string) (int, rune, string) {
+	if len(s) < 2 {
+		return len(s), 0, "unterminated escape sequence"
+	}
+	switch s[1] {
+	case 'n':
+		return 2, '\n', ""
+	case 't':
+		return 2, '\t', ""
+	case 'r':
+		return 2, '\r', ""
+	case '"', '\\':
+		return 2, rune(s[1]), ""
+	case 'u':
+		n := min(len(s), 6)
+		code, err := strconv.ParseUint(s[2:n], 16, 32)
+		if n < 6 || err != nil {
+			return n, 0, "\\u must be followed by four hex digits"
+		}
+		return n, rune(code), ""
+	}
+	_, size := utf8.DecodeRuneInString(s[1:])
+	return 1 + size, 0, fmt.Sprintf("unknown escape sequence \\%s", s[1:1+size])
+}
+
+// stringNode joins adjacent literal parts, yielding a plain String
+// when nothing was interpolated
+func stringNode(pos, end Position, parts []node) node {
+	joined := make([]node, 0, len(parts))
+	for _, part := range parts {
+		if s, ok := part.(*String); ok && len(joined) > 0 {
+			if prev, ok := joined[len(joined)-1].(*String); ok {
+				joined[len(joined)-1] = &String{prev.Position, prev.Text + s.Text, s.EndPosition}
+				continue
+			}
+		}
+		joined = append(joined, part)
+	}
+	switch {
+	case len(joined) == 0:
+		return &String{pos, "", end}
+	case len(joined) == 1:
+		if s, ok := joined[0].(*String); ok {
+			return &String{pos, s.Text, end}
+		}
+	}
+	return &Interpolation{pos, joined, end}
+}
+
+// _blockString strips the indentation from the content lines of a
+// block scalar, then folds (>) and chomps them. Chomping clips the
+// content to a single trailing newline by default, strips it
+// entirely with '-' and keeps every trailing blank line with '+'.
+func _blockString(p *parser) node {
+	tok := p.current
+	header, body, found := strings.Cut(tok.val, "\n")
+	folded := header[0] == '>'
+	var chomp byte
+	if len(header) > 1 && (header[1] == '-' || header[1] == '+') {
+		chomp = header[1]
+	}
+	var lines []string
+	if found {
+		lines = strings.Split(body, "\n")
+	}
+
+	indent := 0
+	for _, line := range lines {
+		if trimmed := strings.TrimLeft(line, " "); trimmed != "" {
+			indent = len(line) - len(trimmed)
+			break
+		}
+	}
+	// the position of each line's content
+	positions := make([]Position, len(lines))
+	pos := Position{
+		ByteOffset: tok.position.ByteOffset + uint(len(header)) + 1,
+		LineNumber: tok.position.LineNumber + 1,
+	}
+	for i, line := range lines {
+		positions[i] = pos
+		if strings.TrimLeft(line, " ") == "" {
+			lines[i] = ""
+		} else {
+			lines[i] = line[indent:]
+			positions[i].ByteOffset += uint(indent)
+			positions[i].CharacterOffset = uint(indent)
+		}
+		pos.ByteOffset += uint(len(line)) + 1
+		pos.LineNumber++
+	}
+
+	trailing := 0
+	for trailing < len(lines) && lines[len(lines)-1-trailing] == "" {
+		trailing++
+	}
+	content := lines[:len(lines)-trailing]
+
+	var (
+		parts []node
+		err   node
+		prev  = -1 // previous non-empty line
+	)
+	for i, line := range content {
+		if folded && line == "" {
+			continue
+		}
+		var sep string
+		switch {
+		case !folded:
+			if i > 0 {
+				sep = "\n"
+			}
+		case prev < 0:
+			sep = strings.Repeat("\n", i)
+		case strings.HasPrefix(line, " ") || strings.HasPrefix(content[prev], " "):
+			// more indented lines aren't folded
+			sep = strings.Repeat("\n", i-prev)
+		case i-prev == 1:
+			sep = " "
+		default:
+			sep = strings.Repeat("\n", i-prev-1)
+		}
+		if sep != "" {
+			// separators stand in for line breaks, they have
+			// no source text of their own
+			parts = append(parts, &String{positions[i], sep, positions[i]})
+		}
+		if parts, err = p.interpolate(parts, line, positions[i], false); err != nil {
+			return err
+		}
+		prev = i
+	}
+
+	switch {
+	case chomp == '-':
+	case chomp == '+':
+		newlines := trailing
+		if len(content) > 0 {
+			newlines++
+		}
+		parts = append(parts, &String{pos, strings.Repeat("\n", newlines), pos})
+	case len(content) > 0:
+		parts = append(parts, &String{pos, "\n", pos})
+	}
+	return stringNode(tok.position, tok.end, parts)
+}
+
+// parseEmbedded parses an interpolation, ${expr}, found at pos. It
+// must hold a single expression.
+func (p *parser) parseEmbedded(text string, pos Position) node {
+	src := text[len("${") : len(text)-len("}")]
+	sub := NewParser(newFragmentLexer(src, advance(pos, "${")), p.uri)
+	expr := sub.parseExpression(precedenceLowest)
+	if _, ok := expr.(errorNode); ok {
+		if sub.current.typ == itemEOF {
+			// the expression ran into the closing brace, ${1 + }
+			tok := &item{itemString, text, pos, endOf(pos, text), p.current.indent}
+			return &ParseError{File: p.uri, Message: "incomplete expression in interpolation", Token: tok}
+		}
+		return expr
+	}
+	if !sub.isEOF() {
+		sub.accept()
+		return sub._error("expected end of interpolation")
+	}
+	return expr
 }
 
 func _path(p *parser) node {
 	spec := urispec.Parse(p.current.val)
-	return &Path{p.current.position, spec}
+	return &Path{p.current.position, spec, p.current.end}
 }
 
 func _number(p *parser) node {
@@ -458,8 +971,51 @@ func _number(p *parser) node {
 	return &Number{p.current.position, v}
 }
 
+func _bool(p *parser) node {
+	switch p.current.val {
+	case "true", "True", "TRUE":
+		return &Boolean{p.current.position, true}
+	default:
+		return &Boolean{p.current.position, false}
+	}
+}
+
+func _null(p *parser) node {
+	return &Null{p.current.position}
+}
+
+// symbol parses a symbol. Outside of map keys a dotted symbol,
+// app.name, is a chain of field selections.
 func symbol(p *parser) node {
-	return &Symbol{p.current.position, p.current.val}
+	sym := &Symbol{Position: p.current.position, Text: p.current.val, Doc: p.leadComment}
+	if p.peek().typ == itemColon && !p.colonEnds {
+		return sym
+	}
+	return dottedPath(sym)
+}
+
+// _select parses field access following an expression, xs[0].name
+func _select(p *parser, left node) node {
+	if p.peek().typ != itemSymbol {
+		p.accept()
+		return p._error("expected a field name after '.'")
+	}
+	p.accept()
+	return selectPath(left, p.current.val, p.current.position)
+}
+
+func _index(p *parser, left node) node {
+	bracket := p.current.position
+	key := p.parseExpression(precedenceLowest)
+	if err, ok := key.(errorNode); ok {
+		return err
+	}
+	if p.peek().typ != itemRightBracket {
+		p.accept()
+		return p._error("expected ']'")
+	}
+	p.accept()
+	return &Index{Expr: left, Key: key, BracketPos: bracket, EndPosition: p.current.end}
 }
 
 func _map(p *parser, key node) node {
@@ -468,8 +1024,7 @@ func _map(p *parser, key node) node {
 	if prior, ok := p.priorNode.(*Map); ok && p.currentIndent == p.priorIndent {
 		m = prior
 	} else {
-		tmp := make(Map)
-		m = &tmp
+		m = new(Map)
 	}
 
 	indent := p.currentIndent
@@ -479,23 +1034,37 @@ func _map(p *parser, key node) node {
 	p.priorNode = nil
 	p.priorIndent = 0
 
-	value := p.parseExpression(precedenceEquality)
-	if err, ok := value.(errorNode); ok {
+	sym, err := mapKey(p, key)
+	if err != nil {
 		return err
 	}
-	(*m)[*key.(*Symbol)] = value
+
+	value := p.parseExpression(precedenceLowest)
+	if _, ok := value.(errorNode); ok {
+		// keep the error in place of the value and carry on
+		// with the next key
+		p.synchronize(indent)
+	}
+	pattern, _ := key.(*Pattern)
+	*m = append(*m, &MapEntry{Key: *sym, Value: value, Pattern: pattern})
 	p.priorNode = oldNode
 	p.priorIndent = oldIndent
 
 	for {
 		next := p.peek()
-		if next.typ != itemSymbol || next.indent != indent {
+		if !isKeyToken(next.typ) || next.indent != indent {
 			break
 		}
 		p.accept()
-		k := symbol(p)
 		if p.peek().typ != itemColon {
-			return p._error("expected ':'")
+			key := Symbol{Position: p.current.position, Text: p.current.val}
+			*m = append(*m, &MapEntry{Key: key, Value: p._error("expected ':'")})
+			p.synchronize(indent)
+			continue
+		}
+		k, err := mapKey(p, keyNode(p))
+		if err != nil {
+			return err
 		}
 		p.accept()
 
@@ -504,11 +1073,11 @@ func _map(p *parser, key node) node {
 		p.priorNode = nil
 		p.priorIndent = 0
 
-		val := p.parseExpression(precedenceEquality)
-		if err, ok := val.(errorNode); ok {
-			return err
+		val := p.parseExpression(precedenceLowest)
+		if _, ok := val.(errorNode); ok {
+			p.synchronize(indent)
 		}
-		(*m)[*k.(*Symbol)] = val
+		*m = append(*m, &MapEntry{Key: *k, Value: val})
 
 		p.priorNode = oldNode
 		p.priorIndent = oldIndent
@@ -525,6 +1094,58 @@ func _map(p *parser, key node) node {
 	return m
 }
 
+// isKeyToken reports whether a token of type typ can begin a
+// map key
+func isKeyToken(typ itemType) bool {
+	switch typ {
+	case itemSymbol, itemMergeKey, itemString, itemRawString, itemBool, itemNull:
+		return true
+	}
+	return false
+}
+
+// keyNode parses the current token, which isKeyToken, as the key
+// of a map entry
+func keyNode(p *parser) node {
+	if p.current.typ == itemSymbol || p.current.typ == itemMergeKey {
+		return symbol(p)
+	}
+	return tokenMap[p.current.typ].parseFn(p)
+}
+
+// mapKey returns the symbol naming a map entry. Literal
+// keys like `true:` are kept as text, the way they end up
+// in a Kubernetes manifest. Quoted keys can hold any text.
+func mapKey(p *parser, key node) (*Symbol, node) {
+	switch k := key.(type) {
+	case *Symbol:
+		return k, nil
+	case *String:
+		return &Symbol{Position: quotedKeyPosition(k), Text: k.Text, Doc: p.docs[k.Position.ByteOffset]}, nil
+	case *Pattern:
+		pos := k.Position
+		pos.ByteLength = k.EndPosition.ByteOffset - pos.ByteOffset
+		return &Symbol{Position: pos, Text: k.String(), Doc: p.docs[pos.ByteOffset]}, nil
+	case *Boolean:
+		return &Symbol{Position: k.Position, Text: strconv.FormatBool(k.Value), Doc: p.docs[k.Position.ByteOffset]}, nil
+	case *Null:
+		return &Symbol{Position: k.Position, Text: "null", Doc: p.docs[k.Position.ByteOffset]}, nil
+	case *Interpolation:
+		return nil, p._error("map keys can't be interpolated")
+	default:
+		return nil, p._error("map keys must be symbols")
+	}
+}
+
+// quotedKeyPosition is the position of a quoted key including its
+// quotes, which a string's position leaves out
+func quotedKeyPosition(s *String) Position {
+	pos := s.Position
+	pos.ByteLength = s.EndPosition.ByteOffset - pos.ByteOffset + 1
+	pos.ByteOffset--
+	return pos
+}
+
 func _list(p *parser) node {
 	var l *List
 	listIndent := p.currentIndent
@@ -535,13 +1156,20 @@ func _list(p *parser) node {
 	}
 
 	for {
-		value := p.parseExpression(precedenceEquality)
-		if err, ok := value.(errorNode); ok {
-			return err
-		}
+		dash := p.current.position
+		doc := p.leadComment
+		// each item is a fresh expression, a map in the prior
+		// item must not absorb the keys of this one
+		p.priorNode = nil
+		value := p.parseExpression(precedenceLowest)
 
 		// consume additional expressions that belong to the same list item
 		for {
+			if _, ok := value.(errorNode); ok {
+				// the error stands in for the item
+				p.synchronize(listIndent)
+				break
+			}
 			next := p.peek()
 			if next.typ == itemEOF || next.indent <= listIndent {
 				break
@@ -549,13 +1177,10 @@ func _list(p *parser) node {
 			if next.typ == itemList && next.indent == listIndent {
 				break
 			}
-			value = p.parseExpression(precedenceEquality)
-			if err, ok := value.(errorNode); ok {
-				return err
-			}
+			value = p.parseExpression(precedenceLowest)
 		}
 
-		*l = append(*l, value)
+		*l = append(*l, &ListItem{Position: dash, Doc: doc, Value: value})
 
 		next := p.peek()
 		if next.typ != itemList || next.indent != listIndent {
@@ -570,46 +1195,322 @@ func _list(p *parser) node {
 }
 
 func _function(p *parser, param node) node {
-	sym, ok := param.(*Symbol)
-	if !ok {
-		return p._error("function parameter must be a symbol")
+	var params []node
+	switch v := param.(type) {
+	case *Symbol, *Pattern:
+		params = []node{v}
+	case *paramGroup:
+		for _, n := range v.params {
+			switch n.(type) {
+			case *Symbol, *Pattern:
+			default:
+				return p._error("function parameter must be a symbol or pattern")
+			}
+			params = append(params, n)
+		}
+	default:
+		return p._error("function parameter must be a symbol or pattern")
 	}
 
-	body := p.parseExpression(precedenceEquality)
+	body := p.parseExpression(precedenceLowest)
 	if err, ok := body.(errorNode); ok {
 		return err
 	}
 
-	f := &Function{Param: sym, Body: body}
+	f := &Function{Position: param.Pos(), Params: params, Body: body}
 	return f
 }
 
+// _flowList parses a flow sequence, e.g. ["--port", "80"]
+func _flowList(p *parser) node {
+	if p.isComprehension() {
+		return p.comprehension(itemRightBracket)
+	}
+	pos := p.current.position
+	l := new(List)
+	err := p.flowEntries(itemRightBracket, func() node {
+		doc := p.leadComment
+		value := p.parseExpression(precedenceLowest)
+		if err, ok := value.(errorNode); ok {
+			return err
+		}
+		*l = append(*l, &ListItem{Position: value.Pos(), Doc: doc, Value: value})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return &Flow{Position: pos, Collection: l, EndPosition: p.current.end}
+}
+
+// _flowMap parses a flow mapping, e.g. {app: redis}, or a pattern,
+// {name, port = 80}, when the first entry has no value
+func _flowMap(p *parser) node {
+	if p.isComprehension() {
+		return p.comprehension(itemRightBrace)
+	}
+	pos := p.current.position
+	m := make(Map, 0)
+	var fields []*PatternField
+	err := p.flowEntries(itemRightBrace, func() node {
+		if !isKeyToken(p.accept().typ) {
+			return p._error("map keys must be symbols")
+		}
+		if next := p.peek().typ; len(m) == 0 && p.current.typ == itemSymbol &&
+			(next == itemComma || next == itemRightBrace || next == itemAssign) {
+			field, err := patternField(p)
+			if err != nil {
+				return err
+			}
+			fields = append(fields, field)
+			return nil
+		}
+		if len(fields) > 0 {
+			p.accept()
+			return p._error("expected ',' or '}' in pattern")
+		}
+		sym, err := mapKey(p, keyNode(p))
+		if err != nil {
+			return err
+		}
+		if p.peek().typ != itemColon {
+			p.accept()
+			return p._error("expected ':'")
+		}
+		p.accept()
+		value := p.parseExpression(precedenceLowest)
+		if err, ok := value.(errorNode); ok {
+			return err
+		}
+		m = append(m, &MapEntry{Key: *sym, Value: value})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		return &Pattern{Position: pos, Fields: fields, EndPosition: p.current.end}
+	}
+	return &Flow{Position: pos, Collection: &m, EndPosition: p.current.end}
+}
+
+// isComprehension reports whether the peeked token, just inside a
+// '[' or '{', is the for of a comprehension: it is followed by one
+// or two names or patterns and then in. Anywhere else for is an
+// ordinary symbol, [for, bar], {a: for} or a rule's for: 5m.
+func (p *parser) isComprehension() bool {
+	if tok := p.peek(); tok.typ != itemSymbol || tok.val != "for" {
+		return false
+	}
+	i := 1
+	for vars := 0; vars < 2; vars++ {
+		switch p.lookahead(i).typ {
+		case itemSymbol:
+			i++
+		case itemLeftBrace:
+			// skip over the pattern, comprehension checks it
+			for depth := 0; ; {
+				switch p.lookahead(i).typ {
+				case itemLeftBrace:
+					depth++
+				case itemRightBrace:
+					depth--
+				case itemEOF:
+					return false
+				}
+				i++
+				if depth == 0 {
+					break
+				}
+			}
+		default:
+			return false
+		}
+		if p.lookahead(i).typ != itemComma {
+			break
+		}
+		i++
+	}
+	return p.lookahead(i).typ == itemIn
+}
+
+// comprehension parses a list or map comprehension from its opening
+// bracket through to the closing one,
+//
+//	[for x in xs if cond: value]
+//	{for x in xs if cond: key: value}
+func (p *parser) comprehension(closing itemType) node {
+	c := &Comprehension{Position: p.current.position}
+	p.accept() // for
+	for {
+		switch p.accept().typ {
+		case itemSymbol:
+			if strings.Contains(p.current.val, ".") {
+				return p._error("expected a name or pattern after 'for'")
+			}
+			c.Vars = append(c.Vars, &Symbol{Position: p.current.position, Text: p.current.val})
+		case itemLeftBrace:
+			pattern := _flowMap(p)
+			if err, ok := pattern.(errorNode); ok {
+				return err
+			}
+			if _, ok := pattern.(*Pattern); !ok {
+				return p._error("expected a name or pattern after 'for'")
+			}
+			c.Vars = append(c.Vars, pattern)
+		default:
+			return p._error("expected a name or pattern after 'for'")
+		}
+		if len(c.Vars) == 2 || p.peek().typ != itemComma {
+			break
+		}
+		p.accept()
+	}
+	p.accept() // in, see isComprehension
+
+	oldColonEnds := p.colonEnds
+	defer func() { p.colonEnds = oldColonEnds }()
+	p.colonEnds = true
+	// expr parses the next part of the comprehension, which must
+	// be followed by want
+	expr := func(want itemType) (node, node) {
+		n := p.parseExpression(precedenceLowest)
+		if err, ok := n.(errorNode); ok {
+			return nil, err
+		}
+		if p.peek().typ != want {
+			p.accept()
+			text, ok := closingText[want]
+			if !ok {
+				text = ":"
+			}
+			return nil, p._error(fmt.Sprintf("expected '%s'", text))
+		}
+		p.accept()
+		return n, nil
+	}
+	var err node
+	c.Iterable = p.parseExpression(precedenceLowest)
+	if err, ok := c.Iterable.(errorNode); ok {
+		return err
+	}
+	switch p.accept().typ {
+	case itemIf:
+		if c.Filter, err = expr(itemColon); err != nil {
+			return err
+		}
+	case itemColon:
+	default:
+		return p._error("expected ':'")
+	}
+	if closing == itemRightBrace {
+		if c.Key, err = expr(itemColon); err != nil {
+			return err
+		}
+	}
+	p.colonEnds = false
+	if c.Value, err = expr(closing); err != nil {
+		return err
+	}
+	c.EndPosition = p.current.end
+	p.priorNode = nil
+	return c
+}
+
+// patternField parses a field of a pattern, a name and an optional
+// default, port = 80
+func patternField(p *parser) (*PatternField, node) {
+	if strings.Contains(p.current.val, ".") {
+		return nil, p._error("pattern fields must be names")
+	}
+	field := &PatternField{Name: Symbol{Position: p.current.position, Text: p.current.val}}
+	if p.peek().typ == itemAssign {
+		p.accept()
+		value := p.parseExpression(precedenceLowest)
+		if err, ok := value.(errorNode); ok {
+			return nil, err
+		}
+		field.Default = value
+	}
+	return field, nil
+}
+
+// flowEntries calls entry for each comma separated entry of a
+// flow collection up to the closing token. A trailing comma is
+// allowed. Entries are parsed afresh, so a map in one entry
+// doesn't absorb the keys of the next.
+func (p *parser) flowEntries(closing itemType, entry func() node) node {
+	for {
+		switch p.peek().typ {
+		case closing:
+			p.accept()
+			p.priorNode = nil
+			return nil
+		case itemEOF:
+			p.accept()
+			return p._error(fmt.Sprintf("expected '%s'", closingText[closing]))
+		}
+		p.priorNode = nil
+		if err := entry(); err != nil {
+			return err
+		}
+		switch p.peek().typ {
+		case itemComma:
+			p.accept()
+		case closing, itemEOF:
+		default:
+			p.accept()
+			return p._error(fmt.Sprintf("expected ',' or '%s'", closingText[closing]))
+		}
+	}
+}
+
+var closingText = map[itemType]string{
+	itemRightBrace:   "}",
+	itemRightBracket: "]",
+}
+
 func _let(p *parser) node {
 	pos := p.current.position
-	bindingsExpr := p.parseExpression(precedenceEquality)
+	done := p.await(itemIn)
+	defer done()
+	bindingsExpr := p.parseExpression(precedenceLowest)
 	if err, ok := bindingsExpr.(errorNode); ok {
 		return err
 	}
+	if f, ok := bindingsExpr.(*Flow); ok {
+		bindingsExpr = f.Collection
+	}
 	m, ok := bindingsExpr.(*Map)
 	if !ok {
 		return p._error("let bindings must be a map")
 	}
+	// an error kept in a binding comes before any that follow
+	// from it, so it's the one reported
+	firstError := func(err node) node {
+		for _, entry := range *m {
+			if _, ok := entry.Value.(errorNode); ok {
+				return entry.Value
+			}
+		}
+		return err
+	}
 
 	// Parse additional binding expressions until we encounter 'in'.
 	for {
 		if p.peek().typ == itemIn {
 			break
 		}
-		next := p.parseExpression(precedenceEquality)
+		next := p.parseExpression(precedenceLowest)
 		if err, ok := next.(errorNode); ok {
-			return err
+			return firstError(err)
 		}
 		nm, ok := next.(*Map)
 		if !ok {
-			return p._error("let bindings must be a map")
+			return firstError(p._error("let bindings must be a map"))
 		}
-		for k, v := range *nm {
-			(*m)[k] = v
+		if nm != m {
+			*m = append(*m, *nm...)
 		}
 	}
 
@@ -622,29 +1523,209 @@ func _let(p *parser) node {
 		return p._error("expected 'in'")
 	}
 	p.accept()
-	body := p.parseExpression(precedenceEquality)
+	done()
+	body := p.parseExpression(precedenceLowest)
 	if err, ok := body.(errorNode); ok {
 		return err
 	}
 	return &Let{Position: pos, Bindings: m, Body: body}
 }
 
+func _if(p *parser) node {
+	pos := p.current.position
+	doneThen := p.await(itemThen)
+	defer doneThen()
+	cond := p.parseExpression(precedenceLowest)
+	if err, ok := cond.(errorNode); ok {
+		return err
+	}
+	if p.peek().typ != itemThen {
+		p.accept()
+		return p._error("expected 'then'")
+	}
+	p.accept()
+	doneThen()
+	doneElse := p.await(itemElse)
+	defer doneElse()
+	p.priorNode = nil
+	then := p.parseExpression(precedenceLowest)
+	if err, ok := then.(errorNode); ok {
+		return err
+	}
+	c := &Conditional{Position: pos, Cond: cond, Then: then}
+	if p.peek().typ == itemElse {
+		p.accept()
+		doneElse()
+		p.priorNode = nil
+		c.Else = p.parseExpression(precedenceLowest)
+		if err, ok := c.Else.(errorNode); ok {
+			return err
+		}
+	}
+	return c
+}
+
 func _shovel(p *parser, left node) node {
+	op := p.current
 	right := p.parseExpression(precedenceEquality)
 	if err, ok := right.(errorNode); ok {
 		return err
 	}
-	return &Shovel{Left: left, Right: right}
+	return &Shovel{OperatorPos: op.position, Left: left, Right: right}
+}
+
+// _leadingShovel reports a shovel with no left operand. The YAML
+// merge key, `<<: base`, is lexed as itemMergeKey, so the shovel
+// operator never takes it for its right operand.
+func _leadingShovel(p *parser) node {
+	return p._error("unexpected '<<'")
+}
+
+// _anchor parses a YAML anchor, `&name value`. Anchors are sugar
+// for let bindings: the value is bound by a let wrapped around the
+// document and both the anchor and its aliases refer to it. The
+// binding's name can't be written as a symbol, so it can't clash
+// with one.
+func _anchor(p *parser) node {
+	tok := p.current
+	name := strings.TrimPrefix(tok.val, "&")
+	var value node
+	if p.anchorIsNull(tok) {
+		value = &Null{Position: tok.position}
+	} else {
+		value = p.parseExpression(precedenceLowest)
+		if err, ok := value.(errorNode); ok {
+			return err
+		}
+	}
+
+	// an alias refers to the latest anchor of its name, a
+	// redefined anchor gets a binding of its own
+	binding := "&" + name
+	for n := 2; p.anchors.Get(binding) != nil; n++ {
+		binding = fmt.Sprintf("&%s#%d", name, n)
+	}
+	p.anchorBinding[name] = binding
+	p.anchors = append(p.anchors, &MapEntry{Key: Symbol{Position: tok.position, Text: binding}, Value: value})
+	return &Symbol{Position: tok.position, Text: binding}
+}
+
+// anchorIsNull reports whether nothing follows the anchor tok, so
+// it names a null. Lines following a list item's dash are nested
+// under it at the same indent as content following the dash.
+func (p *parser) anchorIsNull(tok *item) bool {
+	next := p.peek()
+	switch {
+	case next.typ == itemEOF || p.isDocumentBoundary():
+		return true
+	case next.position.LineNumber == tok.position.LineNumber:
+		return false
+	case p.previous != nil && p.previous.typ == itemList:
+		return next.indent < tok.indent
+	default:
+		return next.indent <= tok.indent
+	}
+}
+
+// _alias parses a YAML alias, *name, which refers to the value of
+// the latest anchor of that name. A '*' that isn't prefixing a name
+// is multiplication, handled by _binary.
+func _alias(p *parser) node {
+	star := p.current
+	if next := p.peek(); next.typ != itemSymbol || !p.adjacent(next) {
+		return p._error("unexpected '*'")
+	}
+	p.accept()
+	name := p.current.val
+	binding, ok := p.anchorBinding[name]
+	if !ok {
+		return p._error(fmt.Sprintf("unknown anchor %s", name))
+	}
+	pos := star.position
+	pos.ByteLength += p.current.position.ByteLength
+	return &Symbol{Position: pos, Text: binding}
+}
+
+// _binary parses the right operand of an infix operator.
+// Operands bind at the operator's own precedence, which
+// makes every binary operator left associative.
+func _binary(p *parser, left node) node {
+	op := p.current
+	right := p.parseExpression(tokenMap[op.typ].Precedence)
+	if err, ok := right.(errorNode); ok {
+		return err
+	}
+	return &BinaryOp{Operator: op.val, OperatorPos: op.position, Left: left, Right: right}
+}
+
+func _unary(p *parser) node {
+	op := p.current
+	operand := p.parseExpression(precedencePrefix)
+	if err, ok := operand.(errorNode); ok {
+		return err
+	}
+	return &UnaryOp{Position: op.position, Operator: op.val, Operand: operand}
+}
+
+// _group parses a parenthesized expression
+func _group(p *parser) node {
+	open := p.current.position
+	exprs, err := p.parseArgs()
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(exprs) == 0 && p.peek().typ == itemArrow:
+		// a function without parameters would be a constant
+		return p._error("a function takes at least one parameter")
+	case p.peek().typ == itemArrow:
+		return &paramGroup{open: open, params: exprs, end: p.current.end}
+	case len(exprs) == 1:
+		return &Group{Position: open, Expr: exprs[0], EndPosition: p.current.end}
+	default:
+		return p._error("expected an expression")
+	}
+}
+
+// paramGroup is the parenthesized parameter list of a function,
+// (a, b) => ...
+type paramGroup struct {
+	open   Position
+	params []node
+	end    Position
 }
 
+func (g *paramGroup) Pos() Position { return g.open }
+
+func (g *paramGroup) End() Position { return g.end }
+
 func _call(p *parser, left node) node {
-	arg := p.parseExpression(precedenceLowest)
-	if err, ok := arg.(errorNode); ok {
+	args, err := p.parseArgs()
+	if err != nil {
 		return err
 	}
+	return &Call{Func: left, Args: args, EndPosition: p.current.end}
+}
+
+// parseArgs parses comma separated expressions up to and
+// including the closing paren
+func (p *parser) parseArgs() ([]node, node) {
+	var args []node
+	for p.peek().typ != itemRightParen {
+		arg := p.parseExpression(precedenceLowest)
+		if err, ok := arg.(errorNode); ok {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.peek().typ != itemComma {
+			break
+		}
+		p.accept()
+	}
 	if p.peek().typ != itemRightParen {
-		return p._error("expected right paren")
+		p.accept()
+		return nil, p._error("expected right paren")
 	}
 	p.accept()
-	return &Call{Func: left, Arg: arg}
+	return args, nil
 }
@@ -11,8 +11,8 @@ func FuzzParse(f *testing.F) {
 		f.Add(s)
 	}
 	f.Fuzz(func(t *testing.T, input string) {
-		_, items := NewStringLexer(input)
-		p := NewParser(items, uri.URI("fuzz"))
+		lex := NewStringLexer(input)
+		p := NewParser(lex, uri.URI("fuzz"))
 		defer func() {
 			if r := recover(); r != nil {
 				t.Fatalf("panic: %v", r)
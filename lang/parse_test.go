@@ -1,6 +1,7 @@
 package lang
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
@@ -38,8 +39,8 @@ func TestCommentText(t *testing.T) {
 }
 
 func parseString(input string) node {
-	_, items := NewStringLexer(input)
-	parser := NewParser(items, uri.URI("test"))
+	lex := NewStringLexer(input)
+	parser := NewParser(lex, uri.URI("test"))
 	return parser.Parse()
 }
 
@@ -47,35 +48,94 @@ func zeroPositions(n node) {
 	switch v := n.(type) {
 	case *String:
 		v.Position = Position{}
+		v.EndPosition = Position{}
 	case *Path:
 		v.Position = Position{}
+		v.EndPosition = Position{}
 	case *Number:
 		v.Position = Position{}
+	case *Boolean:
+		v.Position = Position{}
+	case *Null:
+		v.Position = Position{}
 	case *Symbol:
 		v.Position = Position{}
 	case *List:
-		for i, item := range *v {
-			zeroPositions(item)
-			(*v)[i] = item
+		for _, item := range *v {
+			item.Position = Position{}
+			zeroPositions(item.Value)
 		}
+	case *Group:
+		v.Position = Position{}
+		v.EndPosition = Position{}
+		zeroPositions(v.Expr)
+	case *Flow:
+		v.Position = Position{}
+		v.EndPosition = Position{}
+		zeroPositions(v.Collection)
 	case *Map:
-		newMap := make(Map)
-		for k, val := range *v {
-			zeroPositions(val)
-			k.Position = Position{}
-			newMap[k] = val
+		for _, entry := range *v {
+			zeroPositions(entry.Value)
+			entry.Key.Position = Position{}
+			if entry.Pattern != nil {
+				zeroPositions(entry.Pattern)
+			}
+		}
+	case *Comprehension:
+		v.Position = Position{}
+		v.EndPosition = Position{}
+		for _, n := range v.Symbols() {
+			zeroPositions(n)
+		}
+	case *Pattern:
+		v.Position = Position{}
+		v.EndPosition = Position{}
+		for _, field := range v.Fields {
+			field.Name.Position = Position{}
+			if field.Default != nil {
+				zeroPositions(field.Default)
+			}
 		}
-		*v = newMap
 	case *Function:
-		zeroPositions(v.Param)
+		v.Position = Position{}
+		for _, param := range v.Params {
+			zeroPositions(param)
+		}
 		zeroPositions(v.Body)
 	case *Call:
+		v.EndPosition = Position{}
 		zeroPositions(v.Func)
-		zeroPositions(v.Arg)
+		for _, arg := range v.Args {
+			zeroPositions(arg)
+		}
 	case *Shovel:
+		v.OperatorPos = Position{}
+		zeroPositions(v.Left)
+		zeroPositions(v.Right)
+	case *BinaryOp:
+		v.OperatorPos = Position{}
 		zeroPositions(v.Left)
 		zeroPositions(v.Right)
+	case *UnaryOp:
+		v.Position = Position{}
+		zeroPositions(v.Operand)
+	case *Select:
+		v.FieldPos = Position{}
+		zeroPositions(v.Expr)
+	case *Index:
+		v.BracketPos = Position{}
+		v.EndPosition = Position{}
+		zeroPositions(v.Expr)
+		zeroPositions(v.Key)
+	case *Conditional:
+		v.Position = Position{}
+		zeroPositions(v.Cond)
+		zeroPositions(v.Then)
+		if v.Else != nil {
+			zeroPositions(v.Else)
+		}
 	case *Let:
+		v.Position = Position{}
 		if v.Bindings != nil {
 			zeroPositions(v.Bindings)
 		}
@@ -84,13 +144,34 @@ func zeroPositions(n node) {
 	}
 }
 
+// flow is the flow collection holding n, with zeroed positions
+func flow(n node) *Flow { return &Flow{Collection: n} }
+
+// withoutFlows replaces the flow collections in n with the maps and
+// lists they hold, which are the same as the block ones
+func withoutFlows(n node) node {
+	switch v := n.(type) {
+	case *Flow:
+		return withoutFlows(v.Collection)
+	case *Map:
+		for _, entry := range *v {
+			entry.Value = withoutFlows(entry.Value)
+		}
+	case *List:
+		for _, item := range *v {
+			item.Value = withoutFlows(item.Value)
+		}
+	}
+	return n
+}
+
 // Tests
 
 // // Scalars
 func TestParseString(t *testing.T) {
 	got := parseString("\"yo\"")
 	// assertScalar(t, got, node{})
-	wanted := String{Position{}, "yo"}
+	wanted := String{Position: Position{}, Text: "yo"}
 	zeroPositions(got)
 	if str, ok := got.(*String); ok {
 		if *str != wanted {
@@ -103,7 +184,7 @@ func TestParseString(t *testing.T) {
 
 func TestParsePath(t *testing.T) {
 	got := parseString("../foo")
-	wanted := Path{Position{}, urispec.Parse("../foo")}
+	wanted := Path{Position: Position{}, Spec: urispec.Parse("../foo")}
 	zeroPositions(got)
 	if p, ok := got.(*Path); ok {
 		if !reflect.DeepEqual(*p, wanted) {
@@ -116,7 +197,7 @@ func TestParsePath(t *testing.T) {
 
 func TestParsePathAbsolute(t *testing.T) {
 	got := parseString("/etc/passwd")
-	wanted := Path{Position{}, urispec.Parse("/etc/passwd")}
+	wanted := Path{Position: Position{}, Spec: urispec.Parse("/etc/passwd")}
 	zeroPositions(got)
 	if p, ok := got.(*Path); ok {
 		if !reflect.DeepEqual(*p, wanted) {
@@ -132,10 +213,9 @@ func TestParsePathAbsolute(t *testing.T) {
 func TestParseYamlSimpleMap(t *testing.T) {
 	got := parseString(`foo: "bar"`)
 
-	key := Symbol{Position{}, "foo"}
-	var wanted Map = make(map[Symbol]node)
-	value := &String{Position{}, "bar"}
-	wanted[key] = value
+	wanted := Map{
+		{Key: Symbol{Text: "foo"}, Value: &String{Position: Position{}, Text: "bar"}},
+	}
 
 	zeroPositions(got)
 	if m, ok := got.(*Map); ok {
@@ -150,12 +230,11 @@ func TestParseYamlSimpleMap(t *testing.T) {
 func TestParseMultiYamlMap(t *testing.T) {
 	assert := func(version, code string) {
 		got := parseString(code)
-		foo := Symbol{Position{}, "foo"}
-		bar := Symbol{Position{}, "baz"}
-		var wanted Map = make(map[Symbol]node)
-		value := &String{Position{}, "bar"}
-		wanted[foo] = value
-		wanted[bar] = value
+		value := &String{Position: Position{}, Text: "bar"}
+		wanted := Map{
+			{Key: Symbol{Text: "foo"}, Value: value},
+			{Key: Symbol{Text: "baz"}, Value: value},
+		}
 
 		zeroPositions(got)
 		if m, ok := got.(*Map); ok {
@@ -180,14 +259,12 @@ func TestParseYamlNestedMaps(t *testing.T) {
   baz:
     foo: "bar"`)
 
-	foo := Symbol{Position{}, "foo"}
-	baz := Symbol{Position{}, "baz"}
-	var wanted Map = make(map[Symbol]node)
-	var child Map = make(map[Symbol]node)
-	bar := &String{Position{}, "bar"}
-	wanted[foo] = bar
-	wanted[baz] = &child
-	child[foo] = bar
+	bar := &String{Position: Position{}, Text: "bar"}
+	child := Map{{Key: Symbol{Text: "foo"}, Value: bar}}
+	wanted := Map{
+		{Key: Symbol{Text: "foo"}, Value: bar},
+		{Key: Symbol{Text: "baz"}, Value: &child},
+	}
 
 	zeroPositions(got)
 	if m, ok := got.(*Map); ok {
@@ -202,7 +279,7 @@ func TestParseYamlNestedMaps(t *testing.T) {
 func TestParseYamlSimpleList(t *testing.T) {
 	got := parseString(`- "foo"`)
 
-	wanted := List{&String{Position{}, "foo"}}
+	wanted := List{{Value: &String{Position: Position{}, Text: "foo"}}}
 
 	zeroPositions(got)
 	if l, ok := got.(*List); ok {
@@ -218,7 +295,7 @@ func TestParseYamlMultiList(t *testing.T) {
 	got := parseString(`- "foo"
 - "bar"`)
 
-	wanted := List{&String{Position{}, "foo"}, &String{Position{}, "bar"}}
+	wanted := List{{Value: &String{Position: Position{}, Text: "foo"}}, {Value: &String{Position: Position{}, Text: "bar"}}}
 
 	zeroPositions(got)
 	if l, ok := got.(*List); ok {
@@ -233,17 +310,11 @@ func TestParseYamlMultiList(t *testing.T) {
 func TestParseYamlListOfMaps(t *testing.T) {
 	got := parseString("list:\n- foo: 123\n  bar: 678")
 
-	listSym := Symbol{Position{}, "list"}
-	fooSym := Symbol{Position{}, "foo"}
-	barSym := Symbol{Position{}, "bar"}
-
-	var item Map = make(map[Symbol]node)
-	item[fooSym] = &Number{Position{}, 123}
-	item[barSym] = &Number{Position{}, 678}
-
-	wantedList := List{&item}
-	var wanted Map = make(map[Symbol]node)
-	wanted[listSym] = &wantedList
+	item := Map{
+		{Key: Symbol{Text: "foo"}, Value: &Number{Position{}, 123}},
+		{Key: Symbol{Text: "bar"}, Value: &Number{Position{}, 678}},
+	}
+	wanted := Map{{Key: Symbol{Text: "list"}, Value: &List{{Value: &item}}}}
 
 	zeroPositions(got)
 	if m, ok := got.(*Map); ok {
@@ -257,7 +328,7 @@ func TestParseYamlListOfMaps(t *testing.T) {
 
 func TestParseFunction(t *testing.T) {
 	got := parseString("x => x")
-	wanted := &Function{Param: &Symbol{Position{}, "x"}, Body: &Symbol{Position{}, "x"}}
+	wanted := &Function{Params: []node{&Symbol{Position: Position{}, Text: "x"}}, Body: &Symbol{Position: Position{}, Text: "x"}}
 	zeroPositions(got)
 	if !reflect.DeepEqual(got, wanted) {
 		t.Errorf("function parse mismatch - expected: %#v got: %#v", wanted, got)
@@ -266,16 +337,63 @@ func TestParseFunction(t *testing.T) {
 
 func TestParseShovel(t *testing.T) {
 	got := parseString("a << b")
-	wanted := &Shovel{Left: &Symbol{Position{}, "a"}, Right: &Symbol{Position{}, "b"}}
+	wanted := &Shovel{Left: &Symbol{Position: Position{}, Text: "a"}, Right: &Symbol{Position: Position{}, Text: "b"}}
 	zeroPositions(got)
 	if !reflect.DeepEqual(got, wanted) {
 		t.Errorf("shovel parse mismatch - expected: %#v got: %#v", wanted, got)
 	}
 }
 
+func TestParseShovelOperatorPosition(t *testing.T) {
+	got := parseString("x: base << {replicas: 2}")
+	m := got.(*Map)
+	shovel, ok := m.Get("x").(*Shovel)
+	if !ok {
+		t.Fatalf("expected a Shovel got %#v", m.Get("x"))
+	}
+	if wanted := (Position{8, 2, 0, 8}); shovel.OperatorPos != wanted {
+		t.Errorf("expected operator at %v got %v", wanted, shovel.OperatorPos)
+	}
+}
+
+func TestParseMergeKey(t *testing.T) {
+	got := parseString("<<: base\nname: app\nflow: {<<: base, a: 1}")
+	zeroPositions(got)
+	wanted := &Map{
+		{Key: Symbol{Text: MergeKey}, Value: &Symbol{Text: "base"}},
+		{Key: Symbol{Text: "name"}, Value: &Symbol{Text: "app"}},
+		{Key: Symbol{Text: "flow"}, Value: flow(&Map{
+			{Key: Symbol{Text: MergeKey}, Value: &Symbol{Text: "base"}},
+			{Key: Symbol{Text: "a"}, Value: &Number{Position{}, 1}},
+		})},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseMergeKeyAfterSiblings(t *testing.T) {
+	got := parseString("middle:\n  name: web\n  <<: base\n  port: 80\nlast:\n  name: api\n  <<: base\n")
+	zeroPositions(got)
+	wanted := &Map{
+		{Key: Symbol{Text: "middle"}, Value: &Map{
+			{Key: Symbol{Text: "name"}, Value: &Symbol{Text: "web"}},
+			{Key: Symbol{Text: MergeKey}, Value: &Symbol{Text: "base"}},
+			{Key: Symbol{Text: "port"}, Value: &Number{Position{}, 80}},
+		}},
+		{Key: Symbol{Text: "last"}, Value: &Map{
+			{Key: Symbol{Text: "name"}, Value: &Symbol{Text: "api"}},
+			{Key: Symbol{Text: MergeKey}, Value: &Symbol{Text: "base"}},
+		}},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
 func TestParseCall(t *testing.T) {
 	got := parseString("foo(bar)")
-	wanted := &Call{Func: &Symbol{Position{}, "foo"}, Arg: &Symbol{Position{}, "bar"}}
+	wanted := &Call{Func: &Symbol{Position: Position{}, Text: "foo"}, Args: []node{&Symbol{Position: Position{}, Text: "bar"}}}
 	zeroPositions(got)
 	if !reflect.DeepEqual(got, wanted) {
 		t.Errorf("call parse mismatch - expected: %#v got: %#v", wanted, got)
@@ -284,10 +402,8 @@ func TestParseCall(t *testing.T) {
 
 func TestParseLet(t *testing.T) {
 	got := parseString("let foo: 1 in foo")
-	foo := Symbol{Position{}, "foo"}
-	bindings := make(Map)
-	bindings[foo] = &Number{Position{}, 1}
-	wanted := &Let{Bindings: &bindings, Body: &Symbol{Position{}, "foo"}}
+	bindings := Map{{Key: Symbol{Text: "foo"}, Value: &Number{Position{}, 1}}}
+	wanted := &Let{Bindings: &bindings, Body: &Symbol{Position: Position{}, Text: "foo"}}
 	zeroPositions(got)
 	if !reflect.DeepEqual(got, wanted) {
 		t.Errorf("let parse mismatch - expected: %#v got: %#v", wanted, got)
@@ -297,22 +413,960 @@ func TestParseLet(t *testing.T) {
 func TestParseHandlesLexerErrors(t *testing.T) {
 	// Input with an unterminated string should not cause panics
 	got := parseString("apiVersion: \"v1\"\nkind: \"Service")
-	err, ok := got.(*ParseError)
-	if !ok {
-		t.Fatalf("expected ParseError, got %T", got)
+	errs := CollectParseErrors(got)
+	if len(errs) != 1 {
+		t.Fatalf("expected one ParseError, got %d in %#v", len(errs), got)
 	}
-	if !strings.Contains(err.Error(), "unterminated string") {
+	if err := errs[0]; !strings.Contains(err.Error(), "unterminated string") {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
 
+func TestParseAnchorsAndAliases(t *testing.T) {
+	got := parseString("a: &x 1\nb: *x\nc: 2 *x\nd: &y\ne: [*y]")
+	zeroPositions(got)
+	num := func(n float64) *Number { return &Number{Position{}, n} }
+	ref := func(s string) *Symbol { return &Symbol{Text: s} }
+	anchors := Map{
+		{Key: Symbol{Text: "&x"}, Value: num(1)},
+		{Key: Symbol{Text: "&y"}, Value: &Null{}},
+	}
+	wanted := &Let{
+		Bindings: &anchors,
+		Body: &Map{
+			{Key: Symbol{Text: "a"}, Value: ref("&x")},
+			{Key: Symbol{Text: "b"}, Value: ref("&x")},
+			// a star after an operand is multiplication
+			{Key: Symbol{Text: "c"}, Value: &BinaryOp{Operator: "*", Left: num(2), Right: ref("x")}},
+			{Key: Symbol{Text: "d"}, Value: ref("&y")},
+			{Key: Symbol{Text: "e"}, Value: flow(&List{{Value: ref("&y")}})},
+		},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+
+	errs := CollectParseErrors(parseString("a: *nope\nb: * x"))
+	if len(errs) != 2 || errs[0].Message != "unknown anchor nope" || errs[1].Message != "unexpected '*'" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
+
+func TestParseRecoversFromErrors(t *testing.T) {
+	got := parseString(`a:
+  x: )
+  y: 2
+items:
+  - 1
+  - ]
+  - 3
+foo bar
+b: 3
+c: (
+d: 4`)
+	errs := CollectParseErrors(got)
+	wanted := []struct {
+		msg  string
+		line uint
+	}{
+		{"unhandled null denotation reached for 'itemRightParen'", 1},
+		{"unhandled null denotation reached for 'itemRightBracket'", 5},
+		{"expected ':'", 7},
+		// an unclosed paren runs on to the end of the input
+		{"expected right paren", 10},
+	}
+	if len(errs) != len(wanted) {
+		t.Fatalf("expected %d errors got %d: %v", len(wanted), len(errs), errs)
+	}
+	for i, w := range wanted {
+		if errs[i].Message != w.msg || errs[i].Pos().LineNumber != w.line {
+			t.Errorf("expected %q on line %d got %q on line %d", w.msg, w.line, errs[i].Message, errs[i].Pos().LineNumber)
+		}
+	}
+
+	// the subtrees around the errors survive
+	m := got.(*Map)
+	a := m.Get("a").(*Map)
+	if y, ok := a.Get("y").(*Number); !ok || y.Value != 2 {
+		t.Errorf("expected a.y to be 2 got %#v", a.Get("y"))
+	}
+	items := m.Get("items").(*List)
+	if len(*items) != 3 {
+		t.Fatalf("expected 3 items got %d", len(*items))
+	}
+	if n, ok := (*items)[2].Value.(*Number); !ok || n.Value != 3 {
+		t.Errorf("expected the last item to be 3 got %#v", (*items)[2].Value)
+	}
+	if b, ok := m.Get("b").(*Number); !ok || b.Value != 3 {
+		t.Errorf("expected b to be 3 got %#v", m.Get("b"))
+	}
+}
+
+func TestParseRecoversFromLexerErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		wanted []string
+	}{
+		{"a: 1x\nb: )\nc: ]", []string{
+			`bad number syntax: "1x"`,
+			"unhandled null denotation reached for 'itemRightParen'",
+			"unhandled null denotation reached for 'itemRightBracket'",
+		}},
+		{"a:\tb\nc: ~x\nd: 4", []string{"horizontal tabs are not supported", "unexpected '~'"}},
+		// a flow collection the error was in is abandoned
+		{"a: [1, |x\nb: )", []string{"unexpected '|'", "unhandled null denotation reached for 'itemRightParen'"}},
+	}
+	for _, tt := range tests {
+		got := parseString(tt.input)
+		errs := CollectParseErrors(got)
+		if len(errs) != len(tt.wanted) {
+			t.Fatalf("%q: expected %d errors got %d: %v", tt.input, len(tt.wanted), len(errs), errs)
+		}
+		for i, msg := range tt.wanted {
+			if errs[i].Message != msg || errs[i].Pos().LineNumber != uint(i) {
+				t.Errorf("%q: expected %q on line %d got %q on line %d", tt.input, msg, i, errs[i].Message, errs[i].Pos().LineNumber)
+			}
+		}
+	}
+}
+
+func TestParseRecoversWithinLet(t *testing.T) {
+	tests := []struct {
+		input      string
+		line, char uint
+	}{
+		{"x: 1\nz:\n  let y: ) in y\nw: 2", 2, 9},
+		{"let y: )\n", 0, 7},
+		{"if a then ) else b", 0, 10},
+	}
+	for _, tt := range tests {
+		errs := CollectParseErrors(parseString(tt.input))
+		if len(errs) != 1 {
+			t.Fatalf("%q: expected 1 error got %d: %v", tt.input, len(errs), errs)
+		}
+		pos := errs[0].Pos()
+		if errs[0].Message != "unhandled null denotation reached for 'itemRightParen'" || pos.LineNumber != tt.line || pos.CharacterOffset != tt.char {
+			t.Errorf("%q: expected the ')' error at %d:%d got %q at %d:%d", tt.input, tt.line, tt.char, errs[0].Message, pos.LineNumber, pos.CharacterOffset)
+		}
+	}
+}
+
+func TestParseRecoversFromStrayTokens(t *testing.T) {
+	got := parseString("a: 1\n)\nb: 2\n---\nc: ]\nd: 4")
+	docs, ok := got.(*Documents)
+	if !ok || len(*docs) != 2 {
+		t.Fatalf("expected 2 documents got %#v", got)
+	}
+	// the first document's root can't hold the stray paren
+	invalid, ok := (*docs)[0].(*Invalid)
+	if !ok {
+		t.Fatalf("expected an Invalid document got %#v", (*docs)[0])
+	}
+	root := invalid.Root.(*Map)
+	if len(*root) != 2 || keyNamed(root, "a") == nil || keyNamed(root, "b") == nil {
+		t.Errorf("expected keys a and b got %#v", root)
+	}
+	errs := CollectParseErrors(got)
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors got %d: %v", len(errs), errs)
+	}
+	if line := errs[0].Pos().LineNumber; line != 1 {
+		t.Errorf("expected the first error on line 1 got %d", line)
+	}
+	if line := errs[1].Pos().LineNumber; line != 4 {
+		t.Errorf("expected the second error on line 4 got %d", line)
+	}
+}
+
+func TestParseTrailingExpression(t *testing.T) {
+	got := parseString("a: 1\nb: 2\n[c]")
+	invalid, ok := got.(*Invalid)
+	if !ok {
+		t.Fatalf("expected an Invalid document got %#v", got)
+	}
+	// the keys before the expression are kept
+	if root := invalid.Root.(*Map); len(*root) != 2 {
+		t.Errorf("expected keys a and b got %#v", root)
+	}
+	errs := CollectParseErrors(got)
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error got %d: %v", len(errs), errs)
+	}
+	if errs[0].Message != "unexpected expression after the document's value" || errs[0].Pos().LineNumber != 2 {
+		t.Errorf("unexpected error %v", errs[0])
+	}
+}
+
 func TestParseHandlesTabsGracefully(t *testing.T) {
 	got := parseString("foo:\tbar")
-	err, ok := got.(*ParseError)
-	if !ok {
-		t.Fatalf("expected ParseError, got %T", got)
+	errs := CollectParseErrors(got)
+	if len(errs) != 1 {
+		t.Fatalf("expected one ParseError, got %d in %#v", len(errs), got)
 	}
-	if !strings.Contains(err.Error(), "horizontal tabs") {
+	if err := errs[0]; !strings.Contains(err.Error(), "horizontal tabs") {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+func parseAst(input string) Ast {
+	return NewAst(input, uri.URI("test"))
+}
+
+func keyNamed(m *Map, text string) *Symbol {
+	for _, entry := range *m {
+		if entry.Key.Text == text {
+			return &entry.Key
+		}
+	}
+	return nil
+}
+
+func TestParseDocCommentOnMapKey(t *testing.T) {
+	ast := parseAst("# the api version\n# of the resource\napiVersion: v1\n\n# detached\n\nkind: Service # trailing\nspec: 1")
+	m, ok := ast.RootNode.(*Map)
+	if !ok {
+		t.Fatalf("can't cast to Map: %T", ast.RootNode)
+	}
+	if got := keyNamed(m, "apiVersion").Doc.Text(); got != "the api version\nof the resource\n" {
+		t.Errorf("apiVersion doc: got %q", got)
+	}
+	if doc := keyNamed(m, "kind").Doc; doc != nil {
+		t.Errorf("kind should have no doc, got %q", doc.Text())
+	}
+	if doc := keyNamed(m, "spec").Doc; doc != nil {
+		t.Errorf("trailing comment should not document spec, got %q", doc.Text())
+	}
+	if len(ast.Comments) != 3 {
+		t.Errorf("expected 3 comment groups, got %d", len(ast.Comments))
+	}
+}
+
+func TestParseDocCommentOnQuotedKey(t *testing.T) {
+	got := parseString("# spaced\n\"b c\": 2\n# single\n'd': 3\n# literal\ntrue: 4\n# empty\nnull: 5")
+	m, ok := got.(*Map)
+	if !ok {
+		t.Fatalf("can't cast to Map: %T", got)
+	}
+	for key, want := range map[string]string{"b c": "spaced\n", "d": "single\n", "true": "literal\n", "null": "empty\n"} {
+		sym := keyNamed(m, key)
+		if sym == nil || sym.Doc == nil {
+			t.Errorf("%s: expected a doc comment", key)
+			continue
+		}
+		if got := sym.Doc.Text(); got != want {
+			t.Errorf("%s doc: got %q", key, got)
+		}
+	}
+}
+
+func TestParseDocCommentOnLetBinding(t *testing.T) {
+	got := parseString("let\n  # the port\n  port: 80\nin\nport")
+	l, ok := got.(*Let)
+	if !ok {
+		t.Fatalf("can't cast to Let: %T", got)
+	}
+	if got := keyNamed(l.Bindings, "port").Doc.Text(); got != "the port\n" {
+		t.Errorf("binding doc: got %q", got)
+	}
+}
+
+func TestParseDocCommentOnListItems(t *testing.T) {
+	got := parseString("# first\n- name: a\n  # the port\n  port: 1\n- name: b\n# third\n- \"c\"")
+	l, ok := got.(*List)
+	if !ok {
+		t.Fatalf("can't cast to List: %T", got)
+	}
+	if len(*l) != 3 {
+		t.Fatalf("expected 3 items, got %d", len(*l))
+	}
+	if got := (*l)[0].Doc.Text(); got != "first\n" {
+		t.Errorf("item 0 doc: got %q", got)
+	}
+	if (*l)[1].Doc != nil {
+		t.Errorf("item 1 should have no doc")
+	}
+	if got := (*l)[2].Doc.Text(); got != "third\n" {
+		t.Errorf("item 2 doc: got %q", got)
+	}
+	first := (*l)[0].Value.(*Map)
+	if got := keyNamed(first, "port").Doc.Text(); got != "the port\n" {
+		t.Errorf("port doc: got %q", got)
+	}
+	if len(*first) != 2 || len(*(*l)[1].Value.(*Map)) != 1 {
+		t.Errorf("list items must not share a map: %v", *l)
+	}
+}
+
+func TestParseBoolAndNull(t *testing.T) {
+	got := parseString("a: true\nb: False\nc: ~\ntrue: null")
+	zeroPositions(got)
+	wanted := Map{
+		{Key: Symbol{Text: "a"}, Value: &Boolean{Position{}, true}},
+		{Key: Symbol{Text: "b"}, Value: &Boolean{Position{}, false}},
+		{Key: Symbol{Text: "c"}, Value: &Null{Position{}}},
+		{Key: Symbol{Text: "true"}, Value: &Null{Position{}}},
+	}
+	if m, ok := got.(*Map); ok {
+		if !reflect.DeepEqual(*m, wanted) {
+			t.Errorf("maps aren't equal - expected: %v got: %v", wanted, *m)
+		}
+	} else {
+		t.Errorf("can't cast to Map: %T", got)
+	}
+}
+
+func TestParseDocuments(t *testing.T) {
+	got := parseString("---\nkind: Service\n---\n\n---\nkind: Deployment\nmetadata:\n  name: redis\n...\n")
+	docs, ok := got.(*Documents)
+	if !ok {
+		t.Fatalf("can't cast to Documents: %T", got)
+	}
+	if len(*docs) != 2 {
+		t.Fatalf("expected 2 documents, got %d", len(*docs))
+	}
+	second, ok := (*docs)[1].(*Map)
+	if !ok {
+		t.Fatalf("can't cast to Map: %T", (*docs)[1])
+	}
+	if len(*second) != 2 {
+		t.Errorf("documents must not share a map: %v", *second)
+	}
+	kind := keyNamed(second, "kind")
+	if kind.Position.LineNumber != 5 || kind.Position.ByteOffset != 27 {
+		t.Errorf("unexpected position for kind: %+v", kind.Position)
+	}
+}
+
+func TestParseSingleDocumentWithMarker(t *testing.T) {
+	got := parseString("---\nkind: Service\n")
+	if _, ok := got.(*Map); !ok {
+		t.Fatalf("can't cast to Map: %T", got)
+	}
+}
+
+func TestParseOperatorPrecedence(t *testing.T) {
+	got := parseString("a || b && c == 1 + 2 * -(x)")
+	zeroPositions(got)
+	sym := func(s string) *Symbol { return &Symbol{Text: s} }
+	num := func(n float64) *Number { return &Number{Position{}, n} }
+	bin := func(op string, l, r node) *BinaryOp { return &BinaryOp{Operator: op, Left: l, Right: r} }
+	wanted := bin("||", sym("a"),
+		bin("&&", sym("b"),
+			bin("==", sym("c"),
+				bin("+", num(1),
+					bin("*", num(2), &UnaryOp{Operator: "-", Operand: &Group{Expr: sym("x")}})))))
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("precedence mismatch - expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseOperatorAssociativityAndGrouping(t *testing.T) {
+	got := parseString("10 - (4 - 3) - 2")
+	zeroPositions(got)
+	num := func(n float64) *Number { return &Number{Position{}, n} }
+	wanted := &BinaryOp{Operator: "-",
+		Left:  &BinaryOp{Operator: "-", Left: num(10), Right: &Group{Expr: &BinaryOp{Operator: "-", Left: num(4), Right: num(3)}}},
+		Right: num(2)}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("associativity mismatch - expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseOperatorInMapValue(t *testing.T) {
+	got := parseString("replicas: base.replicas * 2\nport: -5")
+	zeroPositions(got)
+	wanted := Map{
+		{Key: Symbol{Text: "replicas"}, Value: &BinaryOp{Operator: "*", Left: &Select{Expr: &Symbol{Text: "base"}, Field: "replicas"}, Right: &Number{Position{}, 2}}},
+		{Key: Symbol{Text: "port"}, Value: &Number{Position{}, -5}},
+	}
+	if m, ok := got.(*Map); ok {
+		if !reflect.DeepEqual(*m, wanted) {
+			t.Errorf("maps aren't equal - expected: %v got: %v", wanted, *m)
+		}
+	} else {
+		t.Errorf("can't cast to Map: %T", got)
+	}
+}
+
+func TestParseInterpolation(t *testing.T) {
+	got := parseString(`"${registry}/${image}:${tag + 1}"`)
+	interp, ok := got.(*Interpolation)
+	if !ok {
+		t.Fatalf("expected Interpolation got %T", got)
+	}
+	wanted := []node{
+		&Symbol{Position: Position{3, 8, 0, 3}, Text: "registry"},
+		&String{Position: Position{12, 0, 0, 12}, Text: "/", EndPosition: Position{13, 0, 0, 13}},
+		&Symbol{Position: Position{15, 5, 0, 15}, Text: "image"},
+		&String{Position: Position{21, 0, 0, 21}, Text: ":", EndPosition: Position{22, 0, 0, 22}},
+		&BinaryOp{
+			Operator:    "+",
+			OperatorPos: Position{28, 1, 0, 28},
+			Left:        &Symbol{Position: Position{24, 3, 0, 24}, Text: "tag"},
+			Right:       &Number{Position{30, 1, 0, 30}, 1},
+		},
+	}
+	if !reflect.DeepEqual(interp.Parts, wanted) {
+		t.Errorf("parts mismatch - expected: %#v got: %#v", wanted, interp.Parts)
+	}
+}
+
+func TestParseInterpolationEscape(t *testing.T) {
+	got := parseString(`"cost: \${price}"`)
+	wanted := &String{Position: Position{1, 15, 0, 0}, Text: "cost: ${price}", EndPosition: Position{17, 0, 0, 17}}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseInterpolationErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		msg    string
+		offset uint
+	}{
+		{`x: "a ${} b"`, "empty interpolation", 6},
+		{`x: "a ${b c} d"`, "expected end of interpolation", 10},
+		{"x: 1\ny: \"${1 +}\"", "incomplete expression in interpolation", 4},
+		{`x: "a ${1 + }"`, "incomplete expression in interpolation", 6},
+		{`x: "${f(1, }"`, "incomplete expression in interpolation", 4},
+		{"x: |\n  a ${(b}", "incomplete expression in interpolation", 4},
+		{"x: |\n  a ${b\n  }", "unterminated interpolation", 4},
+	}
+	for _, tt := range tests {
+		errs := CollectParseErrors(parseString(tt.input))
+		if len(errs) != 1 {
+			t.Fatalf("%q: expected 1 error got %v", tt.input, errs)
+		}
+		if errs[0].Message != tt.msg {
+			t.Errorf("%q: expected message %q got %q", tt.input, tt.msg, errs[0].Message)
+		}
+		if errs[0].Pos().CharacterOffset != tt.offset {
+			t.Errorf("%q: expected offset %d got %d", tt.input, tt.offset, errs[0].Pos().CharacterOffset)
+		}
+	}
+}
+
+func TestParseStringEscapes(t *testing.T) {
+	tests := []struct {
+		input  string
+		wanted string
+	}{
+		{`"a\tb\nc"`, "a\tb\nc"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"\\"`, `\`},
+		{`"C:\\dir\\"`, `C:\dir\`},
+		{`"caf\u00e9 \u2603"`, "café ☃"},
+		{`"\\${x}"`, `\`},
+		{`'it''s \n'`, `it's \n`},
+		{"`\\d+\\.${x}`", `\d+\.${x}`},
+	}
+	for _, tt := range tests {
+		got := parseString(tt.input)
+		if interp, ok := got.(*Interpolation); ok {
+			got = interp.Parts[0]
+		}
+		if s, ok := got.(*String); !ok || s.Text != tt.wanted {
+			t.Errorf("%s: expected %q got %#v", tt.input, tt.wanted, got)
+		}
+	}
+}
+
+func TestParseStringEscapeErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		msg    string
+		line   uint
+		offset uint
+	}{
+		{`x: "a \q"`, `unknown escape sequence \q`, 0, 6},
+		{`x: "\u12"`, `\u must be followed by four hex digits`, 0, 4},
+		{`x: "\u00zz"`, `\u must be followed by four hex digits`, 0, 4},
+		{"x: \"a\nb\"\ny: \"é\\é\"", `unknown escape sequence \é`, 2, 5},
+	}
+	for _, tt := range tests {
+		errs := CollectParseErrors(parseString(tt.input))
+		if len(errs) != 1 {
+			t.Fatalf("%q: expected 1 error got %v", tt.input, errs)
+		}
+		if errs[0].Message != tt.msg {
+			t.Errorf("%q: expected message %q got %q", tt.input, tt.msg, errs[0].Message)
+		}
+		if pos := errs[0].Pos(); pos.LineNumber != tt.line || pos.CharacterOffset != tt.offset {
+			t.Errorf("%q: expected %d:%d got %d:%d", tt.input, tt.line, tt.offset, pos.LineNumber, pos.CharacterOffset)
+		}
+	}
+}
+
+func TestParseBlockScalars(t *testing.T) {
+	tests := []struct {
+		input  string
+		wanted string
+	}{
+		{"|\n  one\n    two\n\n  three\n", "one\n  two\n\nthree\n"},
+		{"|-\n  one\n  two\n\n", "one\ntwo"},
+		{"|+\n  one\n\n\n", "one\n\n\n"},
+		{">\n  folded\n  text\n\n  para\n    kept\n  end\n", "folded text\npara\n  kept\nend\n"},
+		{">-\n  one\n  two", "one two"},
+		{"|\n  a \\${b}", "a ${b}\n"},
+		{"|", ""},
+	}
+	for _, tt := range tests {
+		got, ok := parseString(tt.input).(*String)
+		if !ok {
+			t.Fatalf("%q: expected String got %T", tt.input, got)
+		}
+		if got.Text != tt.wanted {
+			t.Errorf("%q: expected %q got %q", tt.input, tt.wanted, got.Text)
+		}
+	}
+}
+
+func TestParseBlockScalarInterpolation(t *testing.T) {
+	got := parseString("conf: |\n  workers ${n};\n")
+	m, ok := got.(*Map)
+	if !ok {
+		t.Fatalf("expected Map got %T", got)
+	}
+	interp, ok := m.Get("conf").(*Interpolation)
+	if !ok {
+		t.Fatalf("expected Interpolation got %T", m.Get("conf"))
+	}
+	wanted := []node{
+		&String{Position: Position{10, 0, 1, 2}, Text: "workers ", EndPosition: Position{18, 0, 1, 10}},
+		&Symbol{Position: Position{20, 1, 1, 12}, Text: "n"},
+		&String{Position: Position{22, 0, 1, 14}, Text: ";\n", EndPosition: Position{24, 0, 2, 0}},
+	}
+	if !reflect.DeepEqual(interp.Parts, wanted) {
+		t.Errorf("parts mismatch - expected: %#v got: %#v", wanted, interp.Parts)
+	}
+}
+
+func TestParseFlowMatchesBlock(t *testing.T) {
+	flow := parseString(`spec: {args: ["--port", "80"], selector: {app: redis}, ports: [{port: 80}, {port: 443},], empty: [], none: {}}`)
+	block := parseString(`spec:
+  args:
+  - "--port"
+  - "80"
+  selector:
+    app: redis
+  ports:
+  - port: 80
+  - port: 443
+  empty: []
+  none: {}`)
+	zeroPositions(flow)
+	zeroPositions(block)
+	flow, block = withoutFlows(flow), withoutFlows(block)
+	if !reflect.DeepEqual(flow, block) {
+		t.Errorf("flow and block differ - flow: %#v block: %#v", flow, block)
+	}
+}
+
+func TestParseFlowListOfPairs(t *testing.T) {
+	got := parseString("[a: 1, b: 2]")
+	zeroPositions(got)
+	wanted := flow(&List{
+		{Value: &Map{{Key: Symbol{Text: "a"}, Value: &Number{Position{}, 1}}}},
+		{Value: &Map{{Key: Symbol{Text: "b"}, Value: &Number{Position{}, 2}}}},
+	})
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseFlowErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		msg    string
+		offset uint
+	}{
+		{"x: [1 2]", "expected ',' or ']'", 6},
+		{"x: {a 1}", "expected ':'", 6},
+		{"x: {1: a}", "map keys must be symbols", 4},
+		{"x: [1,\n  2", "expected ']'", 3},
+	}
+	for _, tt := range tests {
+		errs := CollectParseErrors(parseString(tt.input))
+		if len(errs) != 1 {
+			t.Fatalf("%q: expected 1 error got %v", tt.input, errs)
+		}
+		if errs[0].Message != tt.msg {
+			t.Errorf("%q: expected message %q got %q", tt.input, tt.msg, errs[0].Message)
+		}
+		if errs[0].Pos().CharacterOffset != tt.offset {
+			t.Errorf("%q: expected offset %d got %d", tt.input, tt.offset, errs[0].Pos().CharacterOffset)
+		}
+	}
+}
+
+func TestParseConditional(t *testing.T) {
+	got := parseString(`replicas: if env == "prod" then 3 else if env == "stage" then 2 else 1`)
+	zeroPositions(got)
+	env := func(s string) node {
+		return &BinaryOp{Operator: "==", Left: &Symbol{Text: "env"}, Right: &String{Position: Position{}, Text: s}}
+	}
+	wanted := &Map{
+		{Key: Symbol{Text: "replicas"}, Value: &Conditional{
+			Cond: env("prod"),
+			Then: &Number{Position{}, 3},
+			Else: &Conditional{
+				Cond: env("stage"),
+				Then: &Number{Position{}, 2},
+				Else: &Number{Position{}, 1},
+			},
+		}},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseConditionalBlockBranch(t *testing.T) {
+	got := parseString("tls:\n  if secure\n  then\n    secretName: cert\n    hosts: [a]\nport: 1")
+	zeroPositions(got)
+	wanted := &Map{
+		{Key: Symbol{Text: "tls"}, Value: &Conditional{
+			Cond: &Symbol{Text: "secure"},
+			Then: &Map{
+				{Key: Symbol{Text: "secretName"}, Value: &Symbol{Text: "cert"}},
+				{Key: Symbol{Text: "hosts"}, Value: flow(&List{{Value: &Symbol{Text: "a"}}})},
+			},
+		}},
+		{Key: Symbol{Text: "port"}, Value: &Number{Position{}, 1}},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseConditionalMissingThen(t *testing.T) {
+	errs := CollectParseErrors(parseString("x: if a 1 else 2"))
+	if len(errs) != 1 || errs[0].Message != "expected 'then'" {
+		t.Fatalf("expected missing then error, got %v", errs)
+	}
+	if errs[0].Pos().CharacterOffset != 8 {
+		t.Errorf("expected offset 8 got %d", errs[0].Pos().CharacterOffset)
+	}
+}
+
+func TestParseMultiArgFunctionAndCall(t *testing.T) {
+	got := parseString("let\n  add: (a, b) => a + b\nin\nadd(1, f(2))(3)")
+	zeroPositions(got)
+	sym := func(s string) *Symbol { return &Symbol{Text: s} }
+	num := func(n float64) *Number { return &Number{Position{}, n} }
+	wanted := &Let{
+		Bindings: &Map{
+			{Key: Symbol{Text: "add"}, Value: &Function{
+				Params: []node{sym("a"), sym("b")},
+				Body:   &BinaryOp{Operator: "+", Left: sym("a"), Right: sym("b")},
+			}},
+		},
+		Body: &Call{
+			Func: &Call{Func: sym("add"), Args: []node{num(1), &Call{Func: sym("f"), Args: []node{num(2)}}}},
+			Args: []node{num(3)},
+		},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseCurriedFunction(t *testing.T) {
+	got := parseString("a => b => a")
+	zeroPositions(got)
+	wanted := &Function{
+		Params: []node{&Symbol{Text: "a"}},
+		Body:   &Function{Params: []node{&Symbol{Text: "b"}}, Body: &Symbol{Text: "a"}},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseFunctionErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		msg   string
+	}{
+		{"(a, 1) => a", "function parameter must be a symbol or pattern"},
+		{"x: (a, b)", "expected an expression"},
+		{"x: f(a b)", "expected right paren"},
+		{"x: () => 1", "a function takes at least one parameter"},
+	}
+	for _, tt := range tests {
+		errs := CollectParseErrors(parseString(tt.input))
+		if len(errs) != 1 || errs[0].Message != tt.msg {
+			t.Errorf("%q: expected %q got %v", tt.input, tt.msg, errs)
+		}
+	}
+}
+
+func TestParsePatterns(t *testing.T) {
+	got := parseString("let\n  {image, tag = latest}: app.container\n  f: ({name}, x) => x\nin f")
+	zeroPositions(got)
+	pattern := &Pattern{Fields: []*PatternField{
+		{Name: Symbol{Text: "image"}},
+		{Name: Symbol{Text: "tag"}, Default: &Symbol{Text: "latest"}},
+	}}
+	wanted := &Let{
+		Bindings: &Map{
+			{
+				Key:     Symbol{Text: "{image, tag}"},
+				Value:   &Select{Expr: &Symbol{Text: "app"}, Field: "container"},
+				Pattern: pattern,
+			},
+			{Key: Symbol{Text: "f"}, Value: &Function{
+				Params: []node{&Pattern{Fields: []*PatternField{{Name: Symbol{Text: "name"}}}}, &Symbol{Text: "x"}},
+				Body:   &Symbol{Text: "x"},
+			}},
+		},
+		Body: &Symbol{Text: "f"},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParsePatternErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		msg   string
+	}{
+		{"x: {a, b: 1}", "expected ',' or '}' in pattern"},
+		{"x: {a: 1, b}", "expected ':'"},
+		{"x: {a.b}", "pattern fields must be names"},
+		{"x: 1 => x", "function parameter must be a symbol or pattern"},
+	}
+	for _, tt := range tests {
+		errs := CollectParseErrors(parseString(tt.input))
+		if len(errs) != 1 || errs[0].Message != tt.msg {
+			t.Errorf("%q: expected %q got %v", tt.input, tt.msg, errs)
+		}
+	}
+}
+
+func TestParseComprehensions(t *testing.T) {
+	got := parseString(`a: [for t in app.tenants if t.enabled: f(t)]
+b: {for k, {v} in labels: k: v}`)
+	zeroPositions(got)
+	wanted := &Map{
+		{Key: Symbol{Text: "a"}, Value: &Comprehension{
+			Vars:     []node{&Symbol{Text: "t"}},
+			Iterable: &Select{Expr: &Symbol{Text: "app"}, Field: "tenants"},
+			Filter:   &Select{Expr: &Symbol{Text: "t"}, Field: "enabled"},
+			Value:    &Call{Func: &Symbol{Text: "f"}, Args: []node{&Symbol{Text: "t"}}},
+		}},
+		{Key: Symbol{Text: "b"}, Value: &Comprehension{
+			Vars:     []node{&Symbol{Text: "k"}, &Pattern{Fields: []*PatternField{{Name: Symbol{Text: "v"}}}}},
+			Iterable: &Symbol{Text: "labels"},
+			Key:      &Symbol{Text: "k"},
+			Value:    &Symbol{Text: "v"},
+		}},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseForAsSymbol(t *testing.T) {
+	// for only begins a comprehension when a name or pattern and
+	// in follow it, it is a symbol anywhere else
+	tests := []struct {
+		input  string
+		wanted node
+	}{
+		{"for: later", &Map{{Key: Symbol{Text: "for"}, Value: &Symbol{Text: "later"}}}},
+		{"x: [for, bar]", &Map{{Key: Symbol{Text: "x"}, Value: &List{
+			&ListItem{Value: &Symbol{Text: "for"}},
+			&ListItem{Value: &Symbol{Text: "bar"}},
+		}}}},
+		{"x: [for]", &Map{{Key: Symbol{Text: "x"}, Value: &List{
+			&ListItem{Value: &Symbol{Text: "for"}},
+		}}}},
+		{"x: {a: for}", &Map{{Key: Symbol{Text: "x"}, Value: &Map{
+			{Key: Symbol{Text: "a"}, Value: &Symbol{Text: "for"}},
+		}}}},
+		{"x: {for: 1}", &Map{{Key: Symbol{Text: "x"}, Value: &Map{
+			{Key: Symbol{Text: "for"}, Value: &Number{Value: 1}},
+		}}}},
+	}
+	for _, tt := range tests {
+		got := parseString(tt.input)
+		zeroPositions(got)
+		if got := withoutFlows(got); !reflect.DeepEqual(got, tt.wanted) {
+			t.Errorf("%q: expected: %#v got: %#v", tt.input, tt.wanted, got)
+		}
+	}
+}
+
+func TestParseComprehensionErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		msg   string
+	}{
+		{"x: [for a.b in xs: x]", "expected a name or pattern after 'for'"},
+		{"x: [for {a: 1} in xs: x]", "expected a name or pattern after 'for'"},
+		{"x: [for x in xs x]", "expected ':'"},
+		{"x: [for x in xs: x, y]", "expected ']'"},
+		{"x: {for x in xs: x}", "expected ':'"},
+	}
+	for _, tt := range tests {
+		errs := CollectParseErrors(parseString(tt.input))
+		if len(errs) != 1 || errs[0].Message != tt.msg {
+			t.Errorf("%q: expected %q got %v", tt.input, tt.msg, errs)
+		}
+	}
+}
+
+func TestParseSelect(t *testing.T) {
+	got := parseString("port: app.spec.port")
+	m := got.(*Map)
+	wanted := &Select{
+		Expr: &Select{
+			Expr:     &Symbol{Position: Position{6, 3, 0, 6}, Text: "app"},
+			Field:    "spec",
+			FieldPos: Position{10, 4, 0, 10},
+		},
+		Field:    "port",
+		FieldPos: Position{15, 4, 0, 15},
+	}
+	if value := m.Get("port"); !reflect.DeepEqual(value, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, value)
+	}
+}
+
+func TestParseDottedKeysStaySymbols(t *testing.T) {
+	got := parseString("app.kubernetes.io/name: redis\nnginx.conf: x")
+	zeroPositions(got)
+	wanted := &Map{
+		{Key: Symbol{Text: "app.kubernetes.io/name"}, Value: &Symbol{Text: "redis"}},
+		{Key: Symbol{Text: "nginx.conf"}, Value: &Symbol{Text: "x"}},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseQuotedKeys(t *testing.T) {
+	got := parseString(`annotations:
+  "prometheus.io/scrape": "true"
+  'a b': 1
+  "x: y": 2
+flow: {"config.yaml": x, 'it''s': y}`)
+	zeroPositions(got)
+	wanted := &Map{
+		{Key: Symbol{Text: "annotations"}, Value: &Map{
+			{Key: Symbol{Text: "prometheus.io/scrape"}, Value: &String{Text: "true"}},
+			{Key: Symbol{Text: "a b"}, Value: &Number{Position{}, 1}},
+			{Key: Symbol{Text: "x: y"}, Value: &Number{Position{}, 2}},
+		}},
+		{Key: Symbol{Text: "flow"}, Value: flow(&Map{
+			{Key: Symbol{Text: "config.yaml"}, Value: &Symbol{Text: "x"}},
+			{Key: Symbol{Text: "it's"}, Value: &Symbol{Text: "y"}},
+		})},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseQuotedKeyPosition(t *testing.T) {
+	m := parseString(`a: 1
+"b c": 2`).(*Map)
+	if key, wanted := (*m)[1].Key.Position, (Position{5, 5, 1, 0}); key != wanted {
+		t.Errorf("expected: %#v got: %#v", wanted, key)
+	}
+	err, ok := parseString(`"${a}": 1`).(*ParseError)
+	if !ok || !strings.Contains(err.Error(), "map keys can't be interpolated") {
+		t.Errorf("expected an error for an interpolated key got %#v", err)
+	}
+}
+
+func TestParseIndex(t *testing.T) {
+	got := parseString(`a: labels["app.kubernetes.io/name"]
+b: xs[0].name
+c: f(x)[1][2]
+d: [xs, [0]]`)
+	zeroPositions(got)
+	num := func(n float64) *Number { return &Number{Position{}, n} }
+	wanted := &Map{
+		{Key: Symbol{Text: "a"}, Value: &Index{Expr: &Symbol{Text: "labels"}, Key: &String{Position: Position{}, Text: "app.kubernetes.io/name"}}},
+		{Key: Symbol{Text: "b"}, Value: &Select{Expr: &Index{Expr: &Symbol{Text: "xs"}, Key: num(0)}, Field: "name"}},
+		{Key: Symbol{Text: "c"}, Value: &Index{
+			Expr: &Index{Expr: &Call{Func: &Symbol{Text: "f"}, Args: []node{&Symbol{Text: "x"}}}, Key: num(1)},
+			Key:  num(2),
+		}},
+		// a bracket after a space isn't an index
+		{Key: Symbol{Text: "d"}, Value: flow(&List{{Value: &Symbol{Text: "xs"}}, {Value: flow(&List{{Value: num(0)}})}})},
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected: %#v got: %#v", wanted, got)
+	}
+}
+
+func TestParseSpans(t *testing.T) {
+	span := func(n node) string {
+		pos, end := n.Pos(), n.End()
+		return fmt.Sprintf("%d:%d-%d:%d", pos.LineNumber, pos.CharacterOffset, end.LineNumber, end.CharacterOffset)
+	}
+	value := func(input string) node {
+		m := parseString(input).(*Map)
+		return m.Get("v")
+	}
+	tests := []struct {
+		input  string
+		wanted string
+	}{
+		{`v: 12.5`, "0:3-0:7"},
+		{`v: true`, "0:3-0:7"},
+		{`v: ~`, "0:3-0:4"},
+		{`v: "héllo"`, "0:3-0:10"},
+		{`v: "a ${b} c"`, "0:3-0:13"},
+		{`v: ./dir/file.no`, "0:3-0:16"},
+		{`v: héllo`, "0:3-0:8"},
+		{"v: 'it''s'", "0:3-0:10"},
+		{`v: f(a, g(b))`, "0:3-0:13"},
+		{`v: xs[0].name`, "0:3-0:13"},
+		{`v: (a, b) => a + b`, "0:3-0:18"},
+		{`v: (a) => a`, "0:3-0:11"},
+		{`v: a => a`, "0:3-0:9"},
+		{`v: -x.y`, "0:3-0:7"},
+		{`v: if a then b else "c"`, "0:3-0:23"},
+		{"v: |\n  one\n  two\n", "0:3-2:5"},
+		{"v:\n  a: 1\n  b: [1, 2]", "1:2-2:11"},
+		{"v: {a: 1}", "0:3-0:9"},
+		{"v: []", "0:3-0:5"},
+		{"v: {}", "0:3-0:5"},
+		{"v: [\n  1,\n]", "0:3-2:1"},
+		{"v:\n  - x\n  - y: 1\n", "1:2-2:8"},
+		{`v: (a + b) * 2`, "0:3-0:14"},
+		{`v: 2 * (a + b)`, "0:3-0:14"},
+		{`v: ((a))`, "0:3-0:8"},
+		{"v: let\n  x: 1\nin\n  x", "0:3-3:3"},
+	}
+	for _, tt := range tests {
+		if got := span(value(tt.input)); got != tt.wanted {
+			t.Errorf("%q: expected span %s got %s", tt.input, tt.wanted, got)
+		}
+	}
+
+	items := *value("v:\n  - x\n  - [y]").(*List)
+	if got := span(items[1]); got != "2:2-2:7" {
+		t.Errorf("expected the item to span its dash, 2:2-2:7, got %s", got)
+	}
+
+	errs := CollectParseErrors(parseString(`v: "a ${} b"`))
+	if got := span(errs[0]); got != "0:6-0:9" {
+		t.Errorf("expected error span 0:6-0:9 got %s", got)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	input := benchmarkInput()
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewParser(NewStringLexer(input), uri.URI("bench")).Parse()
+	}
+}
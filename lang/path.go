@@ -4,8 +4,11 @@ import "github.com/wycleffsean/nostos/pkg/urispec"
 
 // Path represents a URI specification literal.
 type Path struct {
-	Position Position
-	Spec     urispec.Spec
+	Position    Position
+	Spec        urispec.Spec
+	EndPosition Position
 }
 
 func (p *Path) Pos() Position { return p.Position }
+
+func (p *Path) End() Position { return p.EndPosition }
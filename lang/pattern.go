@@ -0,0 +1,44 @@
+package lang
+
+import "strings"
+
+// Pattern destructures a map, binding each field to a name of the
+// same name, `{name, port = 80} => ...` or
+// `let {image, tag}: app.container in ...`. A field with a default
+// may be missing from the map.
+type Pattern struct {
+	Position    Position
+	Fields      []*PatternField
+	EndPosition Position
+}
+
+// PatternField is a field of a Pattern. Default is nil when the
+// field is required.
+type PatternField struct {
+	Name    Symbol
+	Default node
+}
+
+func (p *Pattern) Pos() Position { return p.Position }
+
+func (p *Pattern) End() Position { return p.EndPosition }
+
+func (p *Pattern) Symbols() []node {
+	symbols := make([]node, 0, len(p.Fields))
+	for _, field := range p.Fields {
+		symbols = append(symbols, &field.Name)
+		if field.Default != nil {
+			symbols = append(symbols, field.Default)
+		}
+	}
+	return symbols
+}
+
+// String is the pattern as written, leaving out defaults
+func (p *Pattern) String() string {
+	names := make([]string, len(p.Fields))
+	for i, field := range p.Fields {
+		names[i] = field.Name.Text
+	}
+	return "{" + strings.Join(names, ", ") + "}"
+}
@@ -98,8 +98,8 @@ func TestQuickCheckPrograms(t *testing.T) {
 	})
 	cfg := &quick.Config{MaxCount: 20}
 	if err := quick.Check(func(p Program) bool {
-		_, items := lang.NewStringLexer(string(p))
-		parser := lang.NewParser(items, uri.URI("quick"))
+		lex := lang.NewStringLexer(string(p))
+		parser := lang.NewParser(lex, uri.URI("quick"))
 		ast := parser.Parse()
 		if errs := lang.CollectParseErrors(ast); len(errs) > 0 {
 			return false
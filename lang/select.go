@@ -0,0 +1,67 @@
+package lang
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Select is a field access, `app.name`. FieldPos is the
+// position of the field name.
+type Select struct {
+	Expr     node
+	Field    string
+	FieldPos Position
+}
+
+func (s *Select) Pos() Position { return s.Expr.Pos() }
+
+func (s *Select) End() Position { return endOf(s.FieldPos, s.Field) }
+
+func (s *Select) Symbols() []node { return []node{s.Expr} }
+
+// Index is a subscript, `xs[0]` or `m["app.kubernetes.io/name"]`.
+// BracketPos is the position of the opening bracket, EndPosition
+// is just past the closing one.
+type Index struct {
+	Expr        node
+	Key         node
+	BracketPos  Position
+	EndPosition Position
+}
+
+func (i *Index) Pos() Position { return i.Expr.Pos() }
+
+func (i *Index) End() Position { return i.EndPosition }
+
+func (i *Index) Symbols() []node { return []node{i.Expr, i.Key} }
+
+// dottedPath splits a symbol like app.name into a chain of field
+// selections. Symbols that aren't a well formed path are returned
+// as they are.
+func dottedPath(sym *Symbol) node {
+	root, path, dotted := strings.Cut(sym.Text, ".")
+	if !dotted || root == "" || path == "" || strings.Contains(path, "..") || strings.HasSuffix(path, ".") {
+		return sym
+	}
+	pos := sym.Position
+	pos.ByteOffset += uint(len(root)) + 1
+	pos.CharacterOffset += uint(utf8.RuneCountInString(root)) + 1
+	rootSym := &Symbol{Position: sym.Position, Text: root, Doc: sym.Doc}
+	rootSym.Position.ByteLength = uint(len(root))
+	return selectPath(rootSym, path, pos)
+}
+
+// selectPath splits the text of a dotted symbol like app.name
+// into field selections on expr, positioning each field within
+// the symbol at pos
+func selectPath(expr node, path string, pos Position) node {
+	fields := strings.Split(path, ".")
+	for _, field := range fields {
+		fieldPos := pos
+		fieldPos.ByteLength = uint(len(field))
+		expr = &Select{Expr: expr, Field: field, FieldPos: fieldPos}
+		pos.ByteOffset += uint(len(field)) + 1
+		pos.CharacterOffset += uint(utf8.RuneCountInString(field)) + 1
+	}
+	return expr
+}
@@ -1,14 +1,20 @@
 package lang
 
-// Shovel represents the infix `<<` operator.
-// It simply links a left and right expression.
+// Shovel represents the infix `<<` operator, which deep merges
+// the right map into the left one.
 type Shovel struct {
-	Left  node
-	Right node
+	OperatorPos Position
+	Left        node
+	Right       node
 }
 
 func (s *Shovel) Pos() Position { return s.Left.Pos() }
 
+func (s *Shovel) End() Position { return s.Right.End() }
+
 func (s *Shovel) leftExpr() node { return s.Left }
 
 func (s *Shovel) rightExpr() node { return s.Right }
+
+// MergeKey is the key of a YAML merge, `<<: base`
+const MergeKey = "<<"
@@ -14,9 +14,9 @@ func TestSymbolTableLookupByName(t *testing.T) {
 	st := NewSymbolTable(registry)
 	doc := uri.New("file://foo.no")
 
-	st.AddSymbol(&Symbol{Position{}, "cab"}, doc)
-	st.AddSymbol(&Symbol{Position{}, "cat"}, doc)
-	st.AddSymbol(&Symbol{Position{}, "bat"}, doc)
+	st.AddSymbol(&Symbol{Position: Position{}, Text: "cab"}, doc)
+	st.AddSymbol(&Symbol{Position: Position{}, Text: "cat"}, doc)
+	st.AddSymbol(&Symbol{Position: Position{}, Text: "bat"}, doc)
 
 	expected := []string{"cab", "cat", "bat"}
 	for _, name := range expected {
@@ -42,8 +42,8 @@ func TestSymbolTableLookupByPosition(t *testing.T) {
 	st := NewSymbolTable(registry)
 	doc := uri.New("file://foo.no")
 
-	foo := &Symbol{Position{LineNumber: 1, CharacterOffset: 2}, "foo"}
-	bar := &Symbol{Position{LineNumber: 3, CharacterOffset: 4}, "bar"}
+	foo := &Symbol{Position: Position{LineNumber: 1, CharacterOffset: 2}, Text: "foo"}
+	bar := &Symbol{Position: Position{LineNumber: 3, CharacterOffset: 4}, Text: "bar"}
 
 	st.AddSymbol(foo, doc)
 	st.AddSymbol(bar, doc)
@@ -62,8 +62,8 @@ func TestSymbolTableReplaceDocumentSymbols(t *testing.T) {
 	docA := uri.New("file://a.no")
 	docB := uri.New("file://b.no")
 
-	a1 := &Symbol{Position{LineNumber: 0, CharacterOffset: 0}, "a1"}
-	b1 := &Symbol{Position{LineNumber: 0, CharacterOffset: 1}, "b1"}
+	a1 := &Symbol{Position: Position{LineNumber: 0, CharacterOffset: 0}, Text: "a1"}
+	b1 := &Symbol{Position: Position{LineNumber: 0, CharacterOffset: 1}, Text: "b1"}
 	st.AddSymbol(a1, docA)
 	st.AddSymbol(b1, docB)
 
@@ -75,7 +75,7 @@ func TestSymbolTableReplaceDocumentSymbols(t *testing.T) {
 		t.Fatalf("symbol from docB should remain")
 	}
 
-	a2 := &Symbol{Position{LineNumber: 1, CharacterOffset: 0}, "a2"}
+	a2 := &Symbol{Position: Position{LineNumber: 1, CharacterOffset: 0}, Text: "a2"}
 	st.AddSymbol(a2, docA)
 
 	if _, ok := st.LookupByName("a2"); !ok {
@@ -0,0 +1,121 @@
+// Package ordered provides the map Nostos programs evaluate to.
+// Keys keep the order they were first set in, so a document is
+// rendered the way it was written.
+package ordered
+
+import "reflect"
+
+// Map is a string keyed map remembering insertion order. The zero
+// value is an empty map ready to use.
+type Map struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// Len returns the number of keys
+func (m *Map) Len() int { return len(m.keys) }
+
+// Keys returns the keys in order. The slice belongs to the map and
+// must not be modified.
+func (m *Map) Keys() []string { return m.keys }
+
+// Get returns the value of key and whether it's set
+func (m *Map) Get(key string) (interface{}, bool) {
+	val, ok := m.values[key]
+	return val, ok
+}
+
+// Set sets the value of key. A new key goes last, an existing one
+// keeps its place.
+func (m *Map) Set(key string, val interface{}) {
+	if m.values == nil {
+		m.values = make(map[string]interface{})
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = val
+}
+
+// Delete removes key, if it's set
+func (m *Map) Delete(key string) {
+	if _, ok := m.values[key]; !ok {
+		return
+	}
+	delete(m.values, key)
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i:i], m.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Copy returns a shallow copy of the map
+func (m *Map) Copy() *Map {
+	c := &Map{}
+	for _, k := range m.keys {
+		c.Set(k, m.values[k])
+	}
+	return c
+}
+
+// Plain converts the maps in v, however deeply nested, to
+// map[string]interface{} for libraries that expect them, like the
+// Kubernetes client. Key order is lost.
+func Plain(v interface{}) interface{} {
+	switch val := v.(type) {
+	case *Map:
+		m := make(map[string]interface{}, val.Len())
+		for _, k := range val.keys {
+			m[k] = Plain(val.values[k])
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			m[k] = Plain(child)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(val))
+		for i, item := range val {
+			l[i] = Plain(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// Equal reports whether a and b are deeply equal. Maps are equal
+// when they hold the same keys and values in any order.
+func Equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case *Map:
+		y, ok := b.(*Map)
+		if !ok || x.Len() != y.Len() {
+			return false
+		}
+		for _, k := range x.keys {
+			yv, ok := y.values[k]
+			if !ok || !Equal(x.values[k], yv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !Equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
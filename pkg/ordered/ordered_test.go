@@ -0,0 +1,83 @@
+package ordered
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMapKeepsInsertionOrder(t *testing.T) {
+	var m Map
+	m.Set("kind", "Service")
+	m.Set("apiVersion", "v1")
+	m.Set("metadata", nil)
+	m.Set("kind", "Deployment")
+	if got := m.Keys(); !reflect.DeepEqual(got, []string{"kind", "apiVersion", "metadata"}) {
+		t.Fatalf("unexpected keys %v", got)
+	}
+	if val, _ := m.Get("kind"); val != "Deployment" {
+		t.Fatalf("expected the later value got %v", val)
+	}
+
+	c := m.Copy()
+	c.Delete("apiVersion")
+	c.Set("spec", 1)
+	if got := c.Keys(); !reflect.DeepEqual(got, []string{"kind", "metadata", "spec"}) {
+		t.Fatalf("unexpected keys after delete %v", got)
+	}
+	if m.Len() != 3 {
+		t.Fatalf("copy modified the original: %v", m.Keys())
+	}
+}
+
+func TestEqualIgnoresKeyOrder(t *testing.T) {
+	a, b := &Map{}, &Map{}
+	a.Set("x", 1.0)
+	a.Set("y", []interface{}{"z"})
+	b.Set("y", []interface{}{"z"})
+	b.Set("x", 1.0)
+	if !Equal(a, b) {
+		t.Fatalf("expected maps to be equal")
+	}
+	b.Set("x", 2.0)
+	if Equal(a, b) {
+		t.Fatalf("expected maps with different values to differ")
+	}
+	if Equal(a, map[string]interface{}{"x": 1.0, "y": []interface{}{"z"}}) {
+		t.Fatalf("expected an ordered map to differ from a plain one")
+	}
+}
+
+func TestPlain(t *testing.T) {
+	inner := &Map{}
+	inner.Set("name", "redis")
+	outer := &Map{}
+	outer.Set("metadata", inner)
+	outer.Set("items", []interface{}{inner})
+	want := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "redis"},
+		"items":    []interface{}{map[string]interface{}{"name": "redis"}},
+	}
+	if got := Plain(outer); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %#v got %#v", want, got)
+	}
+}
+
+func TestMarshalYAML(t *testing.T) {
+	m := &Map{}
+	m.Set("name", "web")
+	m.Set("replicas", int64(2))
+	m.Set("labels", map[string]interface{}{"tier": "front", "app": "web"})
+	m.Set("ports", []interface{}{80.0, 2.5})
+	m.Set("enabled", "yes")
+	got, err := MarshalYAML(m)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := "name: web\nreplicas: 2\nlabels:\n  app: web\n  tier: front\nports:\n  - 80\n  - 2.5\nenabled: \"yes\"\n"
+	if got != want {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+	if _, err := MarshalYAML(struct{}{}); err == nil {
+		t.Fatalf("expected an error for a struct")
+	}
+}
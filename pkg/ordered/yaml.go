@@ -0,0 +1,109 @@
+package ordered
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "sigs.k8s.io/yaml/goyaml.v3"
+)
+
+// UnsupportedValueError is returned by MarshalYAML for a value that
+// has no YAML form, like a function.
+type UnsupportedValueError struct {
+	Value interface{}
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return fmt.Sprintf("cannot encode %T", e.Value)
+}
+
+// MarshalYAML encodes v as a YAML document. Maps keep their key
+// order and plain maps are sorted by key, so the output is stable.
+func MarshalYAML(v interface{}) (string, error) {
+	node, err := yamlNode(v)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func yamlNode(val interface{}) (*yaml.Node, error) {
+	switch val := val.(type) {
+	case *Map:
+		return yamlMapping(val.keys, func(k string) interface{} { return val.values[k] })
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return yamlMapping(keys, func(k string) interface{} { return val[k] })
+	case []interface{}:
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range val {
+			child, err := yamlNode(item)
+			if err != nil {
+				return nil, err
+			}
+			seq.Content = append(seq.Content, child)
+		}
+		return seq, nil
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(val)}, nil
+	case string:
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: val}
+		if yaml11Bools[val] {
+			// YAML 1.1 readers, Kubernetes among them, take these
+			// for booleans
+			node.Style = yaml.DoubleQuotedStyle
+		}
+		return node, nil
+	case float64:
+		tag := "!!float"
+		if val == math.Trunc(val) && !math.IsInf(val, 0) {
+			tag = "!!int"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: strconv.FormatFloat(val, 'f', -1, 64)}, nil
+	case int64:
+		// the Kubernetes client decodes whole numbers as int64
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(val, 10)}, nil
+	case int:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(val)}, nil
+	default:
+		return nil, &UnsupportedValueError{val}
+	}
+}
+
+var yaml11Bools = map[string]bool{
+	"y": true, "Y": true, "yes": true, "Yes": true, "YES": true,
+	"n": true, "N": true, "no": true, "No": true, "NO": true,
+	"on": true, "On": true, "ON": true,
+	"off": true, "Off": true, "OFF": true,
+}
+
+func yamlMapping(keys []string, get func(string) interface{}) (*yaml.Node, error) {
+	m := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, k := range keys {
+		child, err := yamlNode(get(k))
+		if err != nil {
+			return nil, err
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}
+		m.Content = append(m.Content, key, child)
+	}
+	return m, nil
+}
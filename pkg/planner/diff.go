@@ -2,9 +2,11 @@ package planner
 
 import (
 	"reflect"
+	"sort"
 
 	"github.com/pmezard/go-difflib/difflib"
-	"sigs.k8s.io/yaml"
+
+	"github.com/wycleffsean/nostos/pkg/ordered"
 )
 
 // DiffResult represents differences between desired resources and cluster state.
@@ -63,23 +65,15 @@ func BuildPlanFromDiff(diff DiffResult) ([]ResourceType, error) {
 }
 
 // DiffString returns a unified diff of two resources focusing on their specs and metadata.
+// Both sides are written in the key order of the desired resource's
+// manifest, so only changed values show up.
 func DiffString(current, desired ResourceType) string {
-	currYAML, _ := yaml.Marshal(map[string]interface{}{
-		"apiVersion": current.APIVersion,
-		"kind":       current.Kind,
-		"metadata":   current.Metadata,
-		"spec":       current.Spec,
-	})
-	desiredYAML, _ := yaml.Marshal(map[string]interface{}{
-		"apiVersion": desired.APIVersion,
-		"kind":       desired.Kind,
-		"metadata":   desired.Metadata,
-		"spec":       desired.Spec,
-	})
+	currYAML, _ := ordered.MarshalYAML(orderLike(diffDocument(current), desired.Source))
+	desiredYAML, _ := ordered.MarshalYAML(orderLike(diffDocument(desired), desired.Source))
 
 	diff := difflib.UnifiedDiff{
-		A:        difflib.SplitLines(string(currYAML)),
-		B:        difflib.SplitLines(string(desiredYAML)),
+		A:        difflib.SplitLines(currYAML),
+		B:        difflib.SplitLines(desiredYAML),
 		FromFile: "cluster",
 		ToFile:   "desired",
 		Context:  3,
@@ -87,3 +81,60 @@ func DiffString(current, desired ResourceType) string {
 	out, _ := difflib.GetUnifiedDiffString(diff)
 	return out
 }
+
+func diffDocument(r ResourceType) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": r.APIVersion,
+		"kind":       r.Kind,
+		"metadata":   r.Metadata,
+		"spec":       r.Spec,
+	}
+}
+
+// orderLike returns v with its maps ordered like those in order. Keys
+// missing from order come after, sorted.
+func orderLike(v, order interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		like, _ := order.(*ordered.Map)
+		var keys []string
+		if like != nil {
+			for _, k := range like.Keys() {
+				if _, ok := val[k]; ok {
+					keys = append(keys, k)
+				}
+			}
+		}
+		var rest []string
+		for k := range val {
+			if like == nil {
+				rest = append(rest, k)
+			} else if _, ok := like.Get(k); !ok {
+				rest = append(rest, k)
+			}
+		}
+		sort.Strings(rest)
+		m := &ordered.Map{}
+		for _, k := range append(keys, rest...) {
+			var childOrder interface{}
+			if like != nil {
+				childOrder, _ = like.Get(k)
+			}
+			m.Set(k, orderLike(val[k], childOrder))
+		}
+		return m
+	case []interface{}:
+		like, _ := order.([]interface{})
+		l := make([]interface{}, len(val))
+		for i, item := range val {
+			var itemOrder interface{}
+			if i < len(like) {
+				itemOrder = like[i]
+			}
+			l[i] = orderLike(item, itemOrder)
+		}
+		return l
+	default:
+		return v
+	}
+}
@@ -1,6 +1,10 @@
 package planner
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/wycleffsean/nostos/pkg/ordered"
+)
 
 func TestDiffResources(t *testing.T) {
 	cluster := []ResourceType{
@@ -25,3 +29,49 @@ func TestDiffResources(t *testing.T) {
 		t.Fatalf("expected unmanaged B got %+v", diff.Unmanaged)
 	}
 }
+
+func TestDiffStringKeepsManifestOrder(t *testing.T) {
+	labels := &ordered.Map{}
+	labels.Set("app", "web")
+	metadata := &ordered.Map{}
+	metadata.Set("name", "web")
+	metadata.Set("labels", labels)
+	spec := &ordered.Map{}
+	spec.Set("replicas", 3.0)
+	spec.Set("paused", false)
+	source := &ordered.Map{}
+	source.Set("kind", "Deployment")
+	source.Set("apiVersion", "apps/v1")
+	source.Set("metadata", metadata)
+	source.Set("spec", spec)
+
+	current := ResourceType{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Metadata:   map[string]interface{}{"name": "web", "labels": map[string]interface{}{"app": "web"}, "uid": "1234"},
+		Spec:       map[string]interface{}{"replicas": int64(2), "paused": false},
+	}
+	desired := ResourceType{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Metadata:   ordered.Plain(metadata).(map[string]interface{}),
+		Spec:       ordered.Plain(spec).(map[string]interface{}),
+		Source:     source,
+	}
+	want := `--- cluster
++++ desired
+@@ -4,8 +4,7 @@
+   name: web
+   labels:
+     app: web
+-  uid: "1234"
+ spec:
+-  replicas: 2
++  replicas: 3
+   paused: false
+ 
+`
+	if got := DiffString(current, desired); got != want {
+		t.Fatalf("unexpected diff:\n%s", got)
+	}
+}
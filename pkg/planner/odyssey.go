@@ -1,7 +1,6 @@
 package planner
 
 import (
-	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -13,6 +12,7 @@ import (
 
 	"github.com/wycleffsean/nostos/lang"
 	"github.com/wycleffsean/nostos/pkg/kube"
+	"github.com/wycleffsean/nostos/pkg/ordered"
 	"github.com/wycleffsean/nostos/pkg/workspace"
 	"github.com/wycleffsean/nostos/vm"
 )
@@ -30,8 +30,8 @@ func EvaluateOdyssey(path string) (map[string]odysseyEntry, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read odyssey file: %w", err)
 	}
-	_, items := lang.NewStringLexer(string(data))
-	p := lang.NewParser(items, uri.File(path))
+	lex := lang.NewStringLexer(string(data))
+	p := lang.NewParser(lex, uri.File(path))
 	ast := p.Parse()
 	if perrs := lang.CollectParseErrors(ast); len(perrs) > 0 {
 		return nil, fmt.Errorf("failed to parse odyssey file: %s", perrs[0].Error())
@@ -41,7 +41,7 @@ func EvaluateOdyssey(path string) (map[string]odysseyEntry, error) {
 		return nil, fmt.Errorf("failed to evaluate odyssey file: %w", err)
 	}
 	entries := make(map[string]odysseyEntry)
-	if err := mapstructure.Decode(val, &entries); err != nil {
+	if err := mapstructure.Decode(ordered.Plain(val), &entries); err != nil {
 		return nil, fmt.Errorf("failed to decode odyssey file: %w", err)
 	}
 	return entries, nil
@@ -85,6 +85,15 @@ func BuildPlanFromOdyssey(ignoreSystemNamespace, ignoreClusterScoped bool) (*Pla
 				paths = append(paths, filepath.Join(workspace.Dir(), v))
 			case map[string]interface{}:
 				inlineObjs = append(inlineObjs, v)
+			case []interface{}:
+				// import() of a multi-document file
+				for _, doc := range v {
+					obj, ok := doc.(map[string]interface{})
+					if !ok {
+						return nil, fmt.Errorf("unsupported odyssey value %T", doc)
+					}
+					inlineObjs = append(inlineObjs, obj)
+				}
 			default:
 				return nil, fmt.Errorf("unsupported odyssey value %T", it)
 			}
@@ -123,7 +132,8 @@ func BuildPlanFromOdyssey(ignoreSystemNamespace, ignoreClusterScoped bool) (*Pla
 }
 
 // loadResourcesFromFiles parses Kubernetes YAML manifests from the given paths
-// and converts them to ResourceType values.
+// and converts them to ResourceType values. A file may hold several `---`
+// separated documents, each of which is a resource.
 func loadResourcesFromFiles(paths []string, defaultNS string) ([]ResourceType, error) {
 	var resources []ResourceType
 	for _, p := range paths {
@@ -131,22 +141,26 @@ func loadResourcesFromFiles(paths []string, defaultNS string) ([]ResourceType, e
 		if err != nil {
 			return nil, err
 		}
-		docs := bytes.Split(data, []byte("\n---"))
+		lex := lang.NewStringLexer(string(data))
+		parser := lang.NewParser(lex, uri.File(p))
+		ast := parser.Parse()
+		if ast == nil {
+			continue
+		}
+		if perrs := lang.CollectParseErrors(ast); len(perrs) > 0 {
+			return nil, fmt.Errorf("parse %s: %s", p, perrs[0].Error())
+		}
+		val, err := vm.EvalWithDir(ast, filepath.Dir(p), uri.File(p))
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", p, err)
+		}
+		docs := []interface{}{val}
+		if _, ok := ast.(*lang.Documents); ok {
+			docs = val.([]interface{})
+		}
 		for _, d := range docs {
-			if len(bytes.TrimSpace(d)) == 0 {
-				continue
-			}
-			_, items := lang.NewStringLexer(string(d))
-			parser := lang.NewParser(items, uri.File(p))
-			ast := parser.Parse()
-			if perrs := lang.CollectParseErrors(ast); len(perrs) > 0 {
-				return nil, fmt.Errorf("parse %s: %s", p, perrs[0].Error())
-			}
-			val, err := vm.EvalWithDir(ast, filepath.Dir(p), uri.File(p))
-			if err != nil {
-				return nil, fmt.Errorf("parse %s: %w", p, err)
-			}
-			obj, ok := val.(map[string]interface{})
+			// manifests go to the Kubernetes client, which takes plain maps
+			obj, ok := ordered.Plain(d).(map[string]interface{})
 			if !ok {
 				return nil, fmt.Errorf("expected map in %s", p)
 			}
@@ -156,6 +170,7 @@ func loadResourcesFromFiles(paths []string, defaultNS string) ([]ResourceType, e
 				fmt.Printf("Warning: failed to convert resource: %v\n", err)
 				continue
 			}
+			rt.Source, _ = d.(*ordered.Map)
 			if defaultNS != "" {
 				if _, ok := rt.Metadata["namespace"]; !ok {
 					rt.Metadata["namespace"] = defaultNS
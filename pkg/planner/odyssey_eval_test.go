@@ -9,6 +9,7 @@ import (
 	"go.lsp.dev/uri"
 
 	"github.com/wycleffsean/nostos/lang"
+	"github.com/wycleffsean/nostos/pkg/ordered"
 	"github.com/wycleffsean/nostos/vm"
 )
 
@@ -18,14 +19,14 @@ func evalExample(name string) (map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	_, items := lang.NewStringLexer(string(data))
-	p := lang.NewParser(items, uri.File(path))
+	lex := lang.NewStringLexer(string(data))
+	p := lang.NewParser(lex, uri.File(path))
 	ast := p.Parse()
 	val, err := vm.EvalWithDir(ast, filepath.Dir(path), uri.File(path))
 	if err != nil {
 		return nil, err
 	}
-	obj, ok := val.(map[string]interface{})
+	obj, ok := ordered.Plain(val).(map[string]interface{})
 	if !ok {
 		return nil, err
 	}
@@ -6,6 +6,7 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	"github.com/wycleffsean/nostos/pkg/kube"
+	"github.com/wycleffsean/nostos/pkg/ordered"
 )
 
 // systemNamespaces lists namespaces considered internal to the cluster.
@@ -23,6 +24,9 @@ type ResourceType struct {
 	Spec       map[string]interface{}
 	// Dependencies lists other resources this resource relies on by ID.
 	Dependencies []string
+	// Source is the evaluated manifest a desired resource came from,
+	// kept for its key order. It's nil for cluster resources.
+	Source *ordered.Map
 }
 
 // Plan represents a unified plan graph that includes both the current cluster state and user-defined resources.
@@ -167,3 +167,36 @@ users:
 		t.Fatalf("unexpected resource %s", id)
 	}
 }
+
+func TestLoadResourcesFromFilesMultiDocument(t *testing.T) {
+	tmp := t.TempDir()
+	manifests := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: first
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: second
+  namespace: other
+`
+	path := filepath.Join(tmp, "manifests.yaml")
+	if err := os.WriteFile(path, []byte(manifests), 0644); err != nil {
+		t.Fatalf("write manifests: %v", err)
+	}
+
+	resources, err := loadResourcesFromFiles([]string{path}, "foo")
+	if err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("expected 2 resources got %d", len(resources))
+	}
+	if id := ResourceID(resources[0]); id != "v1:ConfigMap:foo:first" {
+		t.Fatalf("unexpected resource %s", id)
+	}
+	if id := ResourceID(resources[1]); id != "v1:ConfigMap:other:second" {
+		t.Fatalf("unexpected resource %s", id)
+	}
+}
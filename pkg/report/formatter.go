@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/fatih/color"
 	"github.com/wycleffsean/nostos/lang"
@@ -69,10 +70,7 @@ func formatPretty(ne lang.NostosError, msg string) string {
 				sb.WriteString(prefix + lines[i-1] + "\n")
 				if i == line {
 					underline := strings.Repeat(" ", numWidth+3+int(pos.CharacterOffset))
-					caretCount := 1
-					if pos.ByteLength > 1 {
-						caretCount = int(pos.ByteLength)
-					}
+					caretCount := caretWidth(pos, ne.End(), lines[i-1])
 					underline += color.New(color.FgRed).Sprint(strings.Repeat("^", caretCount))
 					sb.WriteString(underline + "\n")
 				}
@@ -82,6 +80,23 @@ func formatPretty(ne lang.NostosError, msg string) string {
 	return sb.String()
 }
 
+// caretWidth is the number of characters of line, starting at
+// pos, to underline for an error spanning pos to end. Errors
+// running onto later lines are underlined to the end of the line.
+func caretWidth(pos, end lang.Position, line string) int {
+	width := 1
+	switch {
+	case end.LineNumber > pos.LineNumber:
+		width = utf8.RuneCountInString(line) - int(pos.CharacterOffset)
+	case end.LineNumber == pos.LineNumber && end.CharacterOffset > pos.CharacterOffset:
+		width = int(end.CharacterOffset - pos.CharacterOffset)
+	}
+	if width < 1 {
+		return 1
+	}
+	return width
+}
+
 func readLines(path string) ([]string, error) {
 	f, err := os.Open(filepath.Clean(path))
 	if err != nil {
@@ -3,6 +3,8 @@ package types
 import (
 	"fmt"
 	"reflect"
+
+	"github.com/wycleffsean/nostos/pkg/ordered"
 )
 
 // Assert validates that the provided value conforms to the given Type.  It
@@ -26,6 +28,9 @@ func Assert(val interface{}, t Type) error {
 		}
 		return nil
 	case *ObjectType:
+		if om, ok := val.(*ordered.Map); ok {
+			val = ordered.Plain(om)
+		}
 		m, ok := val.(map[string]interface{})
 		if !ok {
 			return fmt.Errorf("expected object")
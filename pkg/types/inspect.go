@@ -3,10 +3,16 @@ package types
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+
+	"github.com/wycleffsean/nostos/pkg/ordered"
 )
 
-// InspectValue returns a YAML-like representation of v.
+// InspectValue returns a YAML-like representation of v. Evaluated
+// maps keep the order their keys were written in, other maps are
+// sorted by key.
 func InspectValue(v interface{}) string {
 	return inspectValue(v, 0)
 }
@@ -14,31 +20,18 @@ func InspectValue(v interface{}) string {
 func inspectValue(v interface{}, indent int) string {
 	indentStr := strings.Repeat("  ", indent)
 	switch val := v.(type) {
+	case *ordered.Map:
+		return inspectMap(val.Keys(), func(k string) interface{} {
+			child, _ := val.Get(k)
+			return child
+		}, indent)
 	case map[string]interface{}:
 		keys := make([]string, 0, len(val))
 		for k := range val {
 			keys = append(keys, k)
 		}
 		sort.Strings(keys)
-		if len(keys) == 0 {
-			return indentStr + "{}\n"
-		}
-		var sb strings.Builder
-		for _, k := range keys {
-			sb.WriteString(indentStr)
-			sb.WriteString(k)
-			sb.WriteString(": ")
-			child := val[k]
-			switch child.(type) {
-			case map[string]interface{}, []interface{}:
-				sb.WriteString("\n")
-				sb.WriteString(inspectValue(child, indent+1))
-			default:
-				sb.WriteString(formatScalar(child))
-				sb.WriteString("\n")
-			}
-		}
-		return sb.String()
+		return inspectMap(keys, func(k string) interface{} { return val[k] }, indent)
 	case []interface{}:
 		if len(val) == 0 {
 			return indentStr + "[]\n"
@@ -48,7 +41,7 @@ func inspectValue(v interface{}, indent int) string {
 			sb.WriteString(indentStr)
 			sb.WriteString("- ")
 			switch item.(type) {
-			case map[string]interface{}, []interface{}:
+			case *ordered.Map, map[string]interface{}, []interface{}:
 				sb.WriteString("\n")
 				sb.WriteString(inspectValue(item, indent+1))
 			default:
@@ -62,6 +55,57 @@ func inspectValue(v interface{}, indent int) string {
 	}
 }
 
+func inspectMap(keys []string, get func(string) interface{}, indent int) string {
+	indentStr := strings.Repeat("  ", indent)
+	if len(keys) == 0 {
+		return indentStr + "{}\n"
+	}
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(indentStr)
+		sb.WriteString(formatKey(k))
+		sb.WriteString(": ")
+		child := get(k)
+		switch child.(type) {
+		case *ordered.Map, map[string]interface{}, []interface{}:
+			sb.WriteString("\n")
+			sb.WriteString(inspectValue(child, indent+1))
+		default:
+			sb.WriteString(formatScalar(child))
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// formatKey quotes k, like a string value, unless it reads back as
+// the same string key when left plain.
+func formatKey(k string) string {
+	if plainKey(k) {
+		return k
+	}
+	return formatScalar(k)
+}
+
+func plainKey(k string) bool {
+	if k == "" || k[0] == '-' || k[0] == '.' {
+		return false
+	}
+	switch strings.ToLower(k) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null":
+		return false
+	}
+	if _, err := strconv.ParseFloat(k, 64); err == nil {
+		return false
+	}
+	for _, r := range k {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_./-", r) {
+			return false
+		}
+	}
+	return true
+}
+
 func formatScalar(v interface{}) string {
 	switch s := v.(type) {
 	case string:
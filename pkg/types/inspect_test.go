@@ -1,6 +1,10 @@
 package types
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/wycleffsean/nostos/pkg/ordered"
+)
 
 func TestInspectValue(t *testing.T) {
 	obj := map[string]interface{}{"foo": "bar", "num": 1.0}
@@ -11,3 +15,51 @@ func TestInspectValue(t *testing.T) {
 		t.Fatalf("unexpected inspect output:\n%s", got)
 	}
 }
+
+func TestInspectValueBoolAndNull(t *testing.T) {
+	got := InspectValue(map[string]interface{}{"enabled": true, "selector": nil})
+	want := "enabled: true\nselector: null\n"
+	if got != want {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
+
+func TestInspectValueKeepsKeyOrder(t *testing.T) {
+	metadata := &ordered.Map{}
+	metadata.Set("name", "web")
+	obj := &ordered.Map{}
+	obj.Set("apiVersion", "v1")
+	obj.Set("kind", "Service")
+	obj.Set("metadata", metadata)
+	got := InspectValue(obj)
+	want := "apiVersion: \"v1\"\nkind: \"Service\"\nmetadata: \n  name: \"web\"\n"
+	if got != want {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
+
+func TestInspectValueQuotesKeys(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"app", "app"},
+		{"my_key", "my_key"},
+		{"prometheus.io/scrape", "prometheus.io/scrape"},
+		{"a:b", `"a:b"`},
+		{"#comment", `"#comment"`},
+		{"  padded", `"  padded"`},
+		{"", `""`},
+		{"with space", `"with space"`},
+		{"-dash", `"-dash"`},
+		{"true", `"true"`},
+		{"80", `"80"`},
+	}
+	for _, tt := range tests {
+		obj := &ordered.Map{}
+		obj.Set(tt.key, 1.0)
+		got := InspectValue(obj)
+		if want := tt.want + ": 1\n"; got != want {
+			t.Errorf("%q: expected %q got %q", tt.key, want, got)
+		}
+	}
+}
@@ -45,16 +45,10 @@ func (s Spec) LocalPath() (string, error) {
 		}
 		return filepath.Abs(s.Path)
 	case "git":
-		cacheDir, err := os.UserCacheDir()
-		if err != nil {
-			cacheDir = os.TempDir()
-		}
-		dir := filepath.Join(cacheDir, "nostos")
-		if err := os.MkdirAll(dir, 0o755); err != nil {
+		if err := os.MkdirAll(CacheDir(), 0o755); err != nil {
 			return "", err
 		}
-		hash := fmt.Sprintf("%x", sha1.Sum([]byte(s.Path)))
-		repoDir := filepath.Join(dir, hash)
+		repoDir := s.ClonePath()
 		if _, err := os.Stat(repoDir); os.IsNotExist(err) {
 			if _, err := git.PlainClone(repoDir, false, &git.CloneOptions{URL: s.Path}); err != nil {
 				return "", err
@@ -65,3 +59,19 @@ func (s Spec) LocalPath() (string, error) {
 		return "", fmt.Errorf("unknown spec type %s", s.Type)
 	}
 }
+
+// ClonePath returns the directory a git spec is cloned into, whether
+// or not it has been cloned yet
+func (s Spec) ClonePath() string {
+	return filepath.Join(CacheDir(), fmt.Sprintf("%x", sha1.Sum([]byte(s.Path))))
+}
+
+// CacheDir returns the directory git repositories are cloned into,
+// one subdirectory per repository
+func CacheDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "nostos")
+}
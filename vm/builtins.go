@@ -2,6 +2,7 @@ package vm
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 
@@ -18,7 +19,109 @@ var builtins map[string]builtinFunc
 func init() {
 	builtins = map[string]builtinFunc{
 		"import": builtinImport,
+		"merge":  builtinMerge,
+
+		"upper":        builtinUpper,
+		"lower":        builtinLower,
+		"trim":         builtinTrim,
+		"replace":      builtinReplace,
+		"split":        builtinSplit,
+		"join":         builtinJoin,
+		"hasPrefix":    builtinHasPrefix,
+		"hasSuffix":    builtinHasSuffix,
+		"substr":       builtinSubstr,
+		"format":       builtinFormat,
+		"printf":       builtinFormat,
+		"regexMatch":   builtinRegexMatch,
+		"regexReplace": builtinRegexReplace,
+
+		"map":      builtinMap,
+		"filter":   builtinFilter,
+		"fold":     builtinFold,
+		"concat":   builtinConcat,
+		"flatten":  builtinFlatten,
+		"length":   builtinLength,
+		"contains": builtinContains,
+		"sort":     builtinSort,
+		"unique":   builtinUnique,
+		"range":    builtinRange,
+		"keys":     builtinKeys,
+		"values":   builtinValues,
+		"get":      builtinGet,
+		"has":      builtinHas,
+		"mergeAll": builtinMergeAll,
+		"omit":     builtinOmit,
+		"pick":     builtinPick,
+		"zip":      builtinZip,
+
+		"base64encode": builtinBase64Encode,
+		"base64decode": builtinBase64Decode,
+		"sha256":       builtinSha256,
+		"toJson":       builtinToJson,
+		"toYaml":       builtinToYaml,
+		"fromJson":     builtinFromJson,
+		"fromYaml":     builtinFromYaml,
+
+		"readFile":  builtinReadFile,
+		"readLines": builtinReadLines,
+		"readDir":   builtinReadDir,
+	}
+}
+
+// argError is a problem with one argument of a builtin, which a
+// call reports at that argument rather than at the whole call
+type argError struct {
+	index int
+	err   error
+}
+
+func (e *argError) Error() string { return e.err.Error() }
+
+// arity checks that a builtin was given between min and max
+// arguments, max being -1 when there's no limit
+func arity(name string, args []interface{}, min, max int) error {
+	n := len(args)
+	switch {
+	case n >= min && (n <= max || max < 0):
+		return nil
+	case max < 0:
+		return fmt.Errorf("%s expects at least %d arguments, got %d", name, min, n)
+	case min == 1 && max == 1:
+		return fmt.Errorf("%s expects 1 argument, got %d", name, n)
+	case min == max:
+		return fmt.Errorf("%s expects %d arguments, got %d", name, min, n)
+	default:
+		return fmt.Errorf("%s expects %d or %d arguments, got %d", name, min, max, n)
+	}
+}
+
+// stringArg returns argument i of a builtin, which must be a string
+func stringArg(name string, args []interface{}, i int) (string, error) {
+	s, ok := args[i].(string)
+	if !ok {
+		return "", &argError{i, fmt.Errorf("%s expects a string, got %s", name, typeName(args[i]))}
+	}
+	return s, nil
+}
+
+// intArg returns argument i of a builtin, which must be a whole
+// number
+func intArg(name string, args []interface{}, i int) (int, error) {
+	n, ok := args[i].(float64)
+	if !ok || n != math.Trunc(n) {
+		return 0, &argError{i, fmt.Errorf("%s expects a whole number, got %s", name, describe(args[i]))}
+	}
+	return int(n), nil
+}
+
+// describe names the type of val for an error, or gives the value
+// of a number, which may be the wrong kind of number
+func describe(val interface{}) string {
+	if n, ok := val.(float64); ok {
+		s, _ := interpolate(n)
+		return s
 	}
+	return typeName(val)
 }
 
 func builtinImport(v *VM, args ...interface{}) error {
@@ -54,13 +157,13 @@ func builtinImport(v *VM, args ...interface{}) error {
 	if err != nil {
 		return err
 	}
-	_, items := lang.NewStringLexer(string(data))
-	p := lang.NewParser(items, uri.File(path))
+	lex := lang.NewStringLexer(string(data))
+	p := lang.NewParser(lex, uri.File(path))
 	ast := p.Parse()
 	if perrs := lang.CollectParseErrors(ast); len(perrs) > 0 {
 		return perrs[0]
 	}
-	res, err := EvalWithDir(ast, filepath.Dir(path), uri.File(path))
+	res, err := EvalModule(ast, filepath.Dir(path), uri.File(path))
 	if err != nil {
 		return err
 	}
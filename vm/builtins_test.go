@@ -8,8 +8,59 @@ import (
 	"testing"
 
 	"go.lsp.dev/uri"
+
+	"github.com/wycleffsean/nostos/pkg/ordered"
+	"github.com/wycleffsean/nostos/pkg/urispec"
+	"github.com/wycleffsean/nostos/pkg/workspace"
 )
 
+// evalCase is a document and the value it evaluates to, with maps
+// compared as plain maps
+type evalCase struct {
+	input  string
+	wanted interface{}
+}
+
+func evalCases(t *testing.T, dir string, tests []evalCase) {
+	t.Helper()
+	for _, tt := range tests {
+		result, err := EvalWithDir(parse(tt.input), dir, uri.URI("test"))
+		if err != nil {
+			t.Fatalf("%s: eval error: %v", tt.input, err)
+		}
+		if got := ordered.Plain(result); !reflect.DeepEqual(got, tt.wanted) {
+			t.Errorf("%s: expected %#v got %#v", tt.input, tt.wanted, got)
+		}
+	}
+}
+
+// evalErrorCase is a document that fails to evaluate, with the
+// message and position of its error
+type evalErrorCase struct {
+	input  string
+	msg    string
+	line   uint
+	offset uint
+}
+
+func evalErrorCases(t *testing.T, dir string, tests []evalErrorCase) {
+	t.Helper()
+	for _, tt := range tests {
+		_, err := EvalWithDir(parse(tt.input), dir, uri.URI("test"))
+		evalErr, ok := err.(*EvalError)
+		if !ok {
+			t.Fatalf("%q: expected EvalError got %T %v", tt.input, err, err)
+		}
+		if evalErr.Msg != tt.msg {
+			t.Errorf("%q: expected message %q got %q", tt.input, tt.msg, evalErr.Msg)
+		}
+		if evalErr.Position.LineNumber != tt.line || evalErr.Position.CharacterOffset != tt.offset {
+			t.Errorf("%q: expected position %d:%d got %d:%d", tt.input, tt.line, tt.offset,
+				evalErr.Position.LineNumber, evalErr.Position.CharacterOffset)
+		}
+	}
+}
+
 func TestBuiltinImport(t *testing.T) {
 	dir := t.TempDir()
 	file := filepath.Join(dir, "example.no")
@@ -25,7 +76,336 @@ func TestBuiltinImport(t *testing.T) {
 		t.Fatalf("eval error: %v", err)
 	}
 	wanted := map[string]interface{}{"foo": float64(1)}
-	if !reflect.DeepEqual(result, wanted) {
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
 		t.Fatalf("expected %#v got %#v", wanted, result)
 	}
 }
+
+func TestBuiltinImportMultiDocument(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "example.no")
+	content := "kind: Service\n---\nkind: Deployment\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	ast := parse(fmt.Sprintf("import(%s)", file))
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := []interface{}{
+		map[string]interface{}{"kind": "Service"},
+		map[string]interface{}{"kind": "Deployment"},
+	}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestBuiltinStrings(t *testing.T) {
+	evalCases(t, ".", []evalCase{
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("ABC")`, "abc"},
+		{`trim("  x \n")`, "x"},
+		{`replace("a-b-c", "-", ".")`, "a.b.c"},
+		{`split("a,b", ",")`, []interface{}{"a", "b"}},
+		{`join(["a", "b"], "/")`, "a/b"},
+		{`join([], "/")`, ""},
+		{`hasPrefix("nginx:1.25", "nginx")`, true},
+		{`hasSuffix("x.yaml", ".yml")`, false},
+		{`substr("héllo", 1, 3)`, "éll"},
+		{`substr("héllo", 2)`, "llo"},
+		{`format("%s-%03d", "app", 7)`, "app-007"},
+		{`printf("%.1f%% %t %v", 99.25, true, 8080)`, "99.2% true 8080"},
+		{`regexMatch("v12", "^v[0-9]+$")`, true},
+		{"regexReplace(\"me@host\", `(\\w+)@(\\w+)`, `${2}/$1`)", "host/me"},
+	})
+}
+
+func TestBuiltinStringErrors(t *testing.T) {
+	evalErrorCases(t, ".", []evalErrorCase{
+		{`upper(1)`, "upper expects a string, got number", 0, 6},
+		{`replace("a", "b")`, "replace expects 3 arguments, got 2", 0, 0},
+		{`split("a", 1)`, "split expects a string, got number", 0, 11},
+		{`join(["a", 1], ",")`, "join expects a list of strings, item 1 is a number", 0, 5},
+		{`substr("abc", 1, 5)`, "substr length 5 out of range for string of length 3 from 1", 0, 17},
+		{`substr("abc", 0.5)`, "substr expects a whole number, got 0.5", 0, 14},
+		{`regexMatch("a", "(")`, "regexMatch: error parsing regexp: missing closing ): `(`", 0, 16},
+		{`format("%d", 1.5)`, "format: %d expects a whole number, got 1.5", 0, 13},
+		{`format("%s %s", "a")`, "format: missing argument for %s", 0, 7},
+		{`format("%s", "a", "b")`, "format: more arguments than verbs", 0, 18},
+	})
+}
+
+func TestBuiltinImportFunctions(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "lib.no")
+	if err := os.WriteFile(file, []byte("double: x => x * 2"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	// a library's value holds functions, only the importing
+	// document's value is rendered
+	ast := parse(fmt.Sprintf("let lib: import(%s) in x: lib.double(2)", file))
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{"x": float64(4)}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestBuiltinCollections(t *testing.T) {
+	apps := "[{name: web, port: 80}, {name: api, port: 8080}]"
+	labels := "{app: web, tier: front}"
+	evalCases(t, ".", []evalCase{
+		{"map(" + apps + ", a => a.name)", []interface{}{"web", "api"}},
+		{"map(" + labels + ", (k, v) => upper(v))", map[string]interface{}{"app": "WEB", "tier": "FRONT"}},
+		{`map(["a"], upper)`, []interface{}{"A"}},
+		{"filter(" + apps + ", a => a.port > 100)", []interface{}{map[string]interface{}{"name": "api", "port": float64(8080)}}},
+		{"filter(" + labels + ", (k, v) => k != \"app\")", map[string]interface{}{"tier": "front"}},
+		{"map(" + labels + ", k => v => k)", map[string]interface{}{"app": "app", "tier": "tier"}},
+		{"fold([1, 2, 3], 10, (acc, x) => acc + x)", float64(16)},
+		{"concat([1], [], [2, 3])", []interface{}{float64(1), float64(2), float64(3)}},
+		{"flatten([1, [2, [3]], []])", []interface{}{float64(1), float64(2), float64(3)}},
+		{"[length([1, 2]), length(" + labels + "), length(\"héllo\")]", []interface{}{float64(2), float64(2), float64(5)}},
+		{"[contains([{a: 1}], {a: 1}), contains(" + labels + ", \"app\"), contains(\"nginx\", \"x\")]", []interface{}{true, true, true}},
+		{"sort([\"b\", \"c\", \"a\"])", []interface{}{"a", "b", "c"}},
+		{"map(sort(" + apps + ", a => a.name), a => a.name)", []interface{}{"api", "web"}},
+		{"unique([1, 2, 1, {a: 1}, {a: 1}])", []interface{}{float64(1), float64(2), map[string]interface{}{"a": float64(1)}}},
+		{"[range(2), range(1, 3), range(5, 0, -2)]", []interface{}{
+			[]interface{}{float64(0), float64(1)},
+			[]interface{}{float64(1), float64(2)},
+			[]interface{}{float64(5), float64(3), float64(1)},
+		}},
+		{"[keys(" + labels + "), values(" + labels + ")]", []interface{}{[]interface{}{"app", "tier"}, []interface{}{"web", "front"}}},
+		{"[get(" + labels + ", \"app\"), get(" + labels + ", \"x\", dflt), get(" + labels + ", \"x\")]", []interface{}{"web", "dflt", nil}},
+		{"has(" + labels + ", \"tier\")", true},
+		{"mergeAll([{a: {x: 1}}, {a: {y: 2}}])", map[string]interface{}{"a": map[string]interface{}{"x": float64(1), "y": float64(2)}}},
+		{"omit(" + labels + ", [\"app\"])", map[string]interface{}{"tier": "front"}},
+		{"pick(" + labels + ", [\"app\", \"x\"])", map[string]interface{}{"app": "web"}},
+		{"zip([a, b], [1, 2])", []interface{}{[]interface{}{"a", float64(1)}, []interface{}{"b", float64(2)}}},
+	})
+}
+
+func TestBuiltinCollectionsKeepOrder(t *testing.T) {
+	result, err := EvalWithDir(parse("pick(mergeAll([{z: 1, a: 2}, {m: 3, z: 4}]), [\"m\", \"z\", \"a\"])"), ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := []string{"z", "a", "m"}
+	if got := result.(*ordered.Map).Keys(); !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected keys %v got %v", wanted, got)
+	}
+}
+
+func TestBuiltinCollectionErrors(t *testing.T) {
+	evalErrorCases(t, ".", []evalErrorCase{
+		{`map(1, upper)`, "map expects a list or map, got number", 0, 4},
+		{`map([1], 2)`, "map expects a function, got number", 0, 9},
+		{`filter([1], x => x)`, "filter expects its function to return a boolean, got number", 0, 0},
+		{`sort([1, "a"])`, "sort expects all numbers or all strings, got number and string", 0, 5},
+		{`range(1, 2, 0)`, "range step must not be zero", 0, 12},
+		{`range(0, 1e12)`, "range would list more than 1000000 numbers", 0, 9},
+		{`range(1e300)`, "range would list more than 1000000 numbers", 0, 6},
+		{`range(0, 1e7, 2)`, "range would list more than 1000000 numbers", 0, 9},
+		{`map({a: 1}, v => v + 1)`, "map over a map expects a function of (key, value), got one of 1 argument", 0, 12},
+		{`filter({a: 1}, v => true)`, "filter over a map expects a function of (key, value), got one of 1 argument", 0, 15},
+		{`keys([1])`, "keys expects a map, got list", 0, 5},
+		{`omit({a: 1}, [1])`, "omit expects a list of keys, item 0 is a number", 0, 13},
+		{`zip([1], [2, 3])`, "zip expects lists of the same length, got 1 and 2", 0, 9},
+	})
+}
+
+func TestBuiltinEncoding(t *testing.T) {
+	config := `{z: 1, a: "true", ports: [80, 2.5], flag: yes, html: "<b>", none: null}`
+	evalCases(t, ".", []evalCase{
+		{`base64encode("hello")`, "aGVsbG8="},
+		{`base64decode("aGVsbG8=")`, "hello"},
+		{`sha256("hello")`, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{"toJson(" + config + ")", `{"z":1,"a":"true","ports":[80,2.5],"flag":"yes","html":"<b>","none":null}`},
+		{"toYaml(" + config + ")", "z: 1\na: \"true\"\nports:\n  - 80\n  - 2.5\nflag: \"yes\"\nhtml: <b>\nnone: null\n"},
+		{`toYaml([])`, "[]\n"},
+		{`fromJson("{\"b\": [1, true, null], \"a\": \"x\"}")`, map[string]interface{}{"b": []interface{}{float64(1), true, nil}, "a": "x"}},
+		{`fromYaml("a: 1\nb: [x, 'true']\n")`, map[string]interface{}{"a": float64(1), "b": []interface{}{"x", "true"}}},
+		{`fromYaml("base: &b {x: 1, y: 2}\nc:\n  y: 3\n  <<: *b\n")`, map[string]interface{}{
+			"base": map[string]interface{}{"x": float64(1), "y": float64(2)},
+			"c":    map[string]interface{}{"x": float64(1), "y": float64(3)},
+		}},
+		{`fromYaml("a: 1\n---\nb: 2\n")`, []interface{}{map[string]interface{}{"a": float64(1)}, map[string]interface{}{"b": float64(2)}}},
+	})
+}
+
+func TestBuiltinEncodingRoundTrip(t *testing.T) {
+	for _, codec := range []string{"Json", "Yaml"} {
+		input := fmt.Sprintf("from%s(to%s({z: {y: 1, x: [a]}, a: \"on\"}))", codec, codec)
+		result, err := EvalWithDir(parse(input), ".", uri.URI("test"))
+		if err != nil {
+			t.Fatalf("%s: eval error: %v", input, err)
+		}
+		m := result.(*ordered.Map)
+		if got, wanted := m.Keys(), []string{"z", "a"}; !reflect.DeepEqual(got, wanted) {
+			t.Errorf("%s: expected keys %v got %v", input, wanted, got)
+		}
+		z, _ := m.Get("z")
+		if got, wanted := z.(*ordered.Map).Keys(), []string{"y", "x"}; !reflect.DeepEqual(got, wanted) {
+			t.Errorf("%s: expected keys %v got %v", input, wanted, got)
+		}
+		if a, _ := m.Get("a"); a != "on" {
+			t.Errorf("%s: expected a to be %q got %#v", input, "on", a)
+		}
+	}
+}
+
+func TestBuiltinFromYamlMergesLikeNostos(t *testing.T) {
+	doc := "b: &b {x: 1, y: {p: 1}, z: 0}\nm:\n  x: 3\n  <<: *b\n  y: {q: 2}\n"
+	native, err := EvalWithDir(parse(doc), ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	decoded, err := EvalWithDir(parse("fromYaml(`"+doc+"`)"), ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if !ordered.Equal(native, decoded) {
+		t.Errorf("expected %#v got %#v", ordered.Plain(native), ordered.Plain(decoded))
+	}
+	m, _ := decoded.(*ordered.Map).Get("m")
+	wanted := map[string]interface{}{"x": float64(3), "y": map[string]interface{}{"p": float64(1), "q": float64(2)}, "z": float64(0)}
+	if got := ordered.Plain(m); !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected %#v got %#v", wanted, got)
+	}
+	nm, _ := native.(*ordered.Map).Get("m")
+	if got, wanted := m.(*ordered.Map).Keys(), nm.(*ordered.Map).Keys(); !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected keys %v got %v", wanted, got)
+	}
+}
+
+func TestBuiltinEncodingErrors(t *testing.T) {
+	evalErrorCases(t, ".", []evalErrorCase{
+		{`base64decode("a")`, "base64decode: illegal base64 data at input byte 0", 0, 13},
+		{`toJson({f: x => x})`, "toJson: cannot encode function", 0, 7},
+		{`fromJson("[1,")`, "fromJson: unexpected end of JSON input", 0, 9},
+		{`fromJson("1 2")`, "fromJson: unexpected data after the value", 0, 9},
+		{`fromYaml("a: [")`, "fromYaml: yaml: line 1: did not find expected node content", 0, 9},
+	})
+}
+
+// fileWorkspace makes a workspace holding files, a map of path to
+// contents, for the duration of a test
+func fileWorkspace(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+	workspace.Set(dir)
+	t.Cleanup(func() { workspace.Set("") })
+	return dir
+}
+
+func TestBuiltinFiles(t *testing.T) {
+	dir := fileWorkspace(t, map[string]string{
+		"nginx.conf":            "worker_processes 1;\n",
+		"hosts":                 "a\r\nb\n\nc",
+		"empty":                 "",
+		"dashboards/b.json":     "{}",
+		"dashboards/a.json":     "[]",
+		"dashboards/nested/x":   "skipped",
+		"app/dashboards/c.json": "1",
+	})
+	evalCases(t, dir, []evalCase{
+		{"readFile(./nginx.conf)", "worker_processes 1;\n"},
+		{`readFile("nginx.conf")`, "worker_processes 1;\n"},
+		{"readFile(./dashboards, \"a.json\")", "[]"},
+		{"readLines(./hosts)", []interface{}{"a", "b", "", "c"}},
+		{"readLines(./empty)", []interface{}{}},
+		{"readDir(./dashboards)", map[string]interface{}{"a.json": "[]", "b.json": "{}"}},
+	})
+
+	// paths are relative to the file being evaluated
+	result, err := EvalWithDir(parse("readDir(./dashboards)"), filepath.Join(dir, "app"), uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got, wanted := result.(*ordered.Map).Keys(), []string{"c.json"}; !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected keys %v got %v", wanted, got)
+	}
+}
+
+func TestBuiltinFilesSandbox(t *testing.T) {
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	if err := os.WriteFile(secret, []byte("hunter2"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	dir := fileWorkspace(t, map[string]string{"links/ok": "ok"})
+	if err := os.Symlink(secret, filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "links", "secret")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	evalErrorCases(t, dir, []evalErrorCase{
+		{"readFile(" + secret + ")", "readFile: " + secret + " is outside the workspace", 0, 9},
+		{"readFile(../" + filepath.Base(outside) + "/secret)", "readFile: " + secret + " is outside the workspace", 0, 9},
+		{"readFile(./link)", "readFile: " + filepath.Join(dir, "link") + " is outside the workspace", 0, 9},
+		{"readFile(./links, \"../../x\")", "readFile: " + filepath.Join(filepath.Dir(dir), "x") + " is outside the workspace", 0, 18},
+		{"readFile(./links, \"/etc/passwd\")", "readFile expects a path relative to ./links, got /etc/passwd", 0, 18},
+		{"readLines(1)", "readLines expects a path, got number", 0, 10},
+	})
+	// links out of the sandbox are left out of a directory
+	evalCases(t, dir, []evalCase{
+		{"readDir(./links)", map[string]interface{}{"ok": "ok"}},
+	})
+}
+
+func TestBuiltinFilesFromRepositories(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	charts := urispec.Parse("github:org/charts").ClonePath()
+	other := urispec.Parse("github:org/other").ClonePath()
+	for path, content := range map[string]string{
+		filepath.Join(charts, "nginx.conf"): "worker_processes 1;\n",
+		filepath.Join(other, "secret"):      "hunter2",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(other, "secret"), filepath.Join(charts, "link")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	dir := fileWorkspace(t, nil)
+
+	evalCases(t, dir, []evalCase{
+		{`readFile("github:org/charts", "nginx.conf")`, "worker_processes 1;\n"},
+		{`readDir("github:org/charts")`, map[string]interface{}{"nginx.conf": "worker_processes 1;\n"}},
+	})
+	// a module imported from a repository reads from that repository
+	evalCases(t, charts, []evalCase{
+		{"readFile(./nginx.conf)", "worker_processes 1;\n"},
+	})
+
+	// only the named repository can be read, and it's never cloned
+	escape := filepath.Join("..", filepath.Base(other), "secret")
+	evalErrorCases(t, dir, []evalErrorCase{
+		{`readFile("github:org/charts", "` + escape + `")`, "readFile: " + filepath.Join(other, "secret") + " is outside github:org/charts", 0, 30},
+		{`readFile("github:org/charts", "link")`, "readFile: " + filepath.Join(charts, "link") + " is outside github:org/charts", 0, 30},
+		{`readFile("github:org/missing", "x")`, "readFile: github:org/missing has not been cloned, import it first", 0, 9},
+	})
+	evalErrorCases(t, charts, []evalErrorCase{
+		{"readFile(" + escape + ")", "readFile: " + filepath.Join(other, "secret") + " is outside the repository", 0, 9},
+	})
+}
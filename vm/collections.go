@@ -0,0 +1,602 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wycleffsean/nostos/lang"
+	"github.com/wycleffsean/nostos/pkg/ordered"
+)
+
+// Collection builtins never depend on Go's map iteration order.
+// Maps keep their keys in the order they were written and lists
+// keep the order of their items, so a manifest renders the same way
+// on every run.
+
+// builtinMap applies f to each item of a list, map(xs, x => ...),
+// or to each key and value of a map, map(m, (k, v) => ...), keeping
+// the keys
+func builtinMap(v *VM, args ...interface{}) error {
+	if err := arity("map", args, 2, 2); err != nil {
+		return err
+	}
+	f, err := funcArg("map", args, 1)
+	if err != nil {
+		return err
+	}
+	switch coll := args[0].(type) {
+	case []interface{}:
+		list := make([]interface{}, len(coll))
+		for i, item := range coll {
+			if list[i], err = v.call(f, item); err != nil {
+				return err
+			}
+		}
+		v.push(list)
+	case *ordered.Map:
+		if err := pairFunc("map", args, 1); err != nil {
+			return err
+		}
+		m := &ordered.Map{}
+		for _, k := range coll.Keys() {
+			val, _ := coll.Get(k)
+			mapped, err := v.call(f, k, val)
+			if err != nil {
+				return err
+			}
+			m.Set(k, mapped)
+		}
+		v.push(m)
+	default:
+		return &argError{0, fmt.Errorf("map expects a list or map, got %s", typeName(args[0]))}
+	}
+	return nil
+}
+
+// builtinFilter keeps the items of a list, filter(xs, x => ...), or
+// the entries of a map, filter(m, (k, v) => ...), for which f is true
+func builtinFilter(v *VM, args ...interface{}) error {
+	if err := arity("filter", args, 2, 2); err != nil {
+		return err
+	}
+	f, err := funcArg("filter", args, 1)
+	if err != nil {
+		return err
+	}
+	switch coll := args[0].(type) {
+	case []interface{}:
+		list := []interface{}{}
+		for _, item := range coll {
+			keep, err := v.predicate("filter", f, item)
+			if err != nil {
+				return err
+			}
+			if keep {
+				list = append(list, item)
+			}
+		}
+		v.push(list)
+	case *ordered.Map:
+		if err := pairFunc("filter", args, 1); err != nil {
+			return err
+		}
+		m := &ordered.Map{}
+		for _, k := range coll.Keys() {
+			val, _ := coll.Get(k)
+			keep, err := v.predicate("filter", f, k, val)
+			if err != nil {
+				return err
+			}
+			if keep {
+				m.Set(k, val)
+			}
+		}
+		v.push(m)
+	default:
+		return &argError{0, fmt.Errorf("filter expects a list or map, got %s", typeName(args[0]))}
+	}
+	return nil
+}
+
+// builtinFold combines the items of a list from the first,
+// fold(xs, init, (acc, x) => ...)
+func builtinFold(v *VM, args ...interface{}) error {
+	if err := arity("fold", args, 3, 3); err != nil {
+		return err
+	}
+	list, err := listArg("fold", args, 0)
+	if err != nil {
+		return err
+	}
+	f, err := funcArg("fold", args, 2)
+	if err != nil {
+		return err
+	}
+	acc := args[1]
+	for _, item := range list {
+		if acc, err = v.call(f, acc, item); err != nil {
+			return err
+		}
+	}
+	v.push(acc)
+	return nil
+}
+
+// builtinConcat joins lists end to end, concat(xs, ys, ...)
+func builtinConcat(v *VM, args ...interface{}) error {
+	result := []interface{}{}
+	for i := range args {
+		list, err := listArg("concat", args, i)
+		if err != nil {
+			return err
+		}
+		result = append(result, list...)
+	}
+	v.push(result)
+	return nil
+}
+
+// builtinFlatten replaces the lists nested in a list, however deep,
+// with their items
+func builtinFlatten(v *VM, args ...interface{}) error {
+	if err := arity("flatten", args, 1, 1); err != nil {
+		return err
+	}
+	list, err := listArg("flatten", args, 0)
+	if err != nil {
+		return err
+	}
+	v.push(flatten([]interface{}{}, list))
+	return nil
+}
+
+func flatten(result, list []interface{}) []interface{} {
+	for _, item := range list {
+		if nested, ok := item.([]interface{}); ok {
+			result = flatten(result, nested)
+		} else {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// builtinLength is the number of items in a list, keys in a map or
+// characters in a string
+func builtinLength(v *VM, args ...interface{}) error {
+	if err := arity("length", args, 1, 1); err != nil {
+		return err
+	}
+	switch coll := args[0].(type) {
+	case []interface{}:
+		v.push(float64(len(coll)))
+	case *ordered.Map:
+		v.push(float64(coll.Len()))
+	case string:
+		v.push(float64(utf8.RuneCountInString(coll)))
+	default:
+		return &argError{0, fmt.Errorf("length expects a list, map or string, got %s", typeName(args[0]))}
+	}
+	return nil
+}
+
+// builtinContains reports whether a list holds an item equal to x,
+// a map has the key x or a string contains the substring x,
+// contains(coll, x)
+func builtinContains(v *VM, args ...interface{}) error {
+	if err := arity("contains", args, 2, 2); err != nil {
+		return err
+	}
+	switch coll := args[0].(type) {
+	case []interface{}:
+		found := false
+		for _, item := range coll {
+			if ordered.Equal(item, args[1]) {
+				found = true
+				break
+			}
+		}
+		v.push(found)
+	case *ordered.Map:
+		key, err := stringArg("contains", args, 1)
+		if err != nil {
+			return err
+		}
+		_, found := coll.Get(key)
+		v.push(found)
+	case string:
+		sub, err := stringArg("contains", args, 1)
+		if err != nil {
+			return err
+		}
+		v.push(strings.Contains(coll, sub))
+	default:
+		return &argError{0, fmt.Errorf("contains expects a list, map or string, got %s", typeName(args[0]))}
+	}
+	return nil
+}
+
+// builtinSort sorts a list of numbers or of strings, or of anything
+// by the number or string f returns for each item, sort(xs) or
+// sort(xs, x => x.name). The sort is stable.
+func builtinSort(v *VM, args ...interface{}) error {
+	if err := arity("sort", args, 1, 2); err != nil {
+		return err
+	}
+	list, err := listArg("sort", args, 0)
+	if err != nil {
+		return err
+	}
+	sortKeys := list
+	if len(args) == 2 {
+		f, err := funcArg("sort", args, 1)
+		if err != nil {
+			return err
+		}
+		sortKeys = make([]interface{}, len(list))
+		for i, item := range list {
+			if sortKeys[i], err = v.call(f, item); err != nil {
+				return err
+			}
+		}
+	}
+	for _, k := range sortKeys {
+		switch k.(type) {
+		case float64, string:
+			if typeName(k) == typeName(sortKeys[0]) {
+				continue
+			}
+		}
+		return &argError{0, fmt.Errorf("sort expects all numbers or all strings, got %s and %s", typeName(sortKeys[0]), typeName(k))}
+	}
+	order := make([]int, len(list))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := sortKeys[order[i]], sortKeys[order[j]]
+		if x, ok := a.(float64); ok {
+			return x < b.(float64)
+		}
+		return a.(string) < b.(string)
+	})
+	sorted := make([]interface{}, len(list))
+	for i, idx := range order {
+		sorted[i] = list[idx]
+	}
+	v.push(sorted)
+	return nil
+}
+
+// builtinUnique drops the items of a list equal to an earlier one
+func builtinUnique(v *VM, args ...interface{}) error {
+	if err := arity("unique", args, 1, 1); err != nil {
+		return err
+	}
+	list, err := listArg("unique", args, 0)
+	if err != nil {
+		return err
+	}
+	result := []interface{}{}
+	for _, item := range list {
+		seen := false
+		for _, kept := range result {
+			if ordered.Equal(item, kept) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			result = append(result, item)
+		}
+	}
+	v.push(result)
+	return nil
+}
+
+// maxRangeLength bounds the lists range makes, so that a typo like
+// range(0, 1e12) is an error rather than exhausting memory
+const maxRangeLength = 1000000
+
+// builtinRange lists the whole numbers from start up to, but not
+// including, end, range(end), range(start, end) or
+// range(start, end, step)
+func builtinRange(v *VM, args ...interface{}) error {
+	if err := arity("range", args, 1, 3); err != nil {
+		return err
+	}
+	bounds := []float64{0, 0, 1}
+	for i := range args {
+		if _, err := intArg("range", args, i); err != nil {
+			return err
+		}
+		bounds[i] = args[i].(float64)
+	}
+	start, end, step := bounds[0], bounds[1], bounds[2]
+	endArg := 1
+	if len(args) == 1 {
+		start, end, endArg = 0, bounds[0], 0
+	}
+	if step == 0 {
+		return &argError{2, fmt.Errorf("range step must not be zero")}
+	}
+	// the bounds are checked as numbers, they may not fit an int
+	if math.Ceil((end-start)/step) > maxRangeLength {
+		return &argError{endArg, fmt.Errorf("range would list more than %d numbers", maxRangeLength)}
+	}
+	list := []interface{}{}
+	for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+		list = append(list, i)
+	}
+	v.push(list)
+	return nil
+}
+
+// builtinKeys lists the keys of a map in order
+func builtinKeys(v *VM, args ...interface{}) error {
+	if err := arity("keys", args, 1, 1); err != nil {
+		return err
+	}
+	m, err := mapArg("keys", args, 0)
+	if err != nil {
+		return err
+	}
+	list := make([]interface{}, 0, m.Len())
+	for _, k := range m.Keys() {
+		list = append(list, k)
+	}
+	v.push(list)
+	return nil
+}
+
+// builtinValues lists the values of a map in the order of its keys
+func builtinValues(v *VM, args ...interface{}) error {
+	if err := arity("values", args, 1, 1); err != nil {
+		return err
+	}
+	m, err := mapArg("values", args, 0)
+	if err != nil {
+		return err
+	}
+	list := make([]interface{}, 0, m.Len())
+	for _, k := range m.Keys() {
+		val, _ := m.Get(k)
+		list = append(list, val)
+	}
+	v.push(list)
+	return nil
+}
+
+// builtinGet returns the value of key in a map, or the default when
+// the key isn't set, get(m, key) or get(m, key, default). Without a
+// default a missing key is null.
+func builtinGet(v *VM, args ...interface{}) error {
+	if err := arity("get", args, 2, 3); err != nil {
+		return err
+	}
+	m, err := mapArg("get", args, 0)
+	if err != nil {
+		return err
+	}
+	key, err := stringArg("get", args, 1)
+	if err != nil {
+		return err
+	}
+	val, ok := m.Get(key)
+	if !ok && len(args) == 3 {
+		val = args[2]
+	}
+	v.push(val)
+	return nil
+}
+
+// builtinHas reports whether a map has key, has(m, key)
+func builtinHas(v *VM, args ...interface{}) error {
+	if err := arity("has", args, 2, 2); err != nil {
+		return err
+	}
+	m, err := mapArg("has", args, 0)
+	if err != nil {
+		return err
+	}
+	key, err := stringArg("has", args, 1)
+	if err != nil {
+		return err
+	}
+	_, ok := m.Get(key)
+	v.push(ok)
+	return nil
+}
+
+// builtinMergeAll deep merges a list of maps, later maps overriding
+// earlier ones the way << does
+func builtinMergeAll(v *VM, args ...interface{}) error {
+	if err := arity("mergeAll", args, 1, 1); err != nil {
+		return err
+	}
+	list, err := listArg("mergeAll", args, 0)
+	if err != nil {
+		return err
+	}
+	var merged interface{} = &ordered.Map{}
+	for i, item := range list {
+		m, ok := item.(*ordered.Map)
+		if !ok {
+			return &argError{0, fmt.Errorf("mergeAll expects a list of maps, item %d is a %s", i, typeName(item))}
+		}
+		merged = deepMerge(merged, m, &defaultMergeOptions, "")
+	}
+	v.push(merged)
+	return nil
+}
+
+// builtinOmit copies a map without the listed keys,
+// omit(m, ["a", "b"])
+func builtinOmit(v *VM, args ...interface{}) error {
+	m, keys, err := mapAndKeys("omit", args)
+	if err != nil {
+		return err
+	}
+	result := m.Copy()
+	for _, k := range keys {
+		result.Delete(k)
+	}
+	v.push(result)
+	return nil
+}
+
+// builtinPick copies only the listed keys of a map, in the map's
+// order, pick(m, ["a", "b"])
+func builtinPick(v *VM, args ...interface{}) error {
+	m, keys, err := mapAndKeys("pick", args)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		wanted[k] = true
+	}
+	result := &ordered.Map{}
+	for _, k := range m.Keys() {
+		if wanted[k] {
+			val, _ := m.Get(k)
+			result.Set(k, val)
+		}
+	}
+	v.push(result)
+	return nil
+}
+
+// builtinZip pairs the items of two lists of the same length,
+// zip([a, b], [1, 2]) is [[a, 1], [b, 2]]
+func builtinZip(v *VM, args ...interface{}) error {
+	if err := arity("zip", args, 2, 2); err != nil {
+		return err
+	}
+	xs, err := listArg("zip", args, 0)
+	if err != nil {
+		return err
+	}
+	ys, err := listArg("zip", args, 1)
+	if err != nil {
+		return err
+	}
+	if len(xs) != len(ys) {
+		return &argError{1, fmt.Errorf("zip expects lists of the same length, got %d and %d", len(xs), len(ys))}
+	}
+	pairs := make([]interface{}, len(xs))
+	for i := range xs {
+		pairs[i] = []interface{}{xs[i], ys[i]}
+	}
+	v.push(pairs)
+	return nil
+}
+
+// mapAndKeys checks the arguments of omit and pick, a map and a
+// list of keys
+func mapAndKeys(name string, args []interface{}) (*ordered.Map, []string, error) {
+	if err := arity(name, args, 2, 2); err != nil {
+		return nil, nil, err
+	}
+	m, err := mapArg(name, args, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	list, err := listArg(name, args, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, len(list))
+	for i, item := range list {
+		k, ok := item.(string)
+		if !ok {
+			return nil, nil, &argError{1, fmt.Errorf("%s expects a list of keys, item %d is a %s", name, i, typeName(item))}
+		}
+		keys[i] = k
+	}
+	return m, keys, nil
+}
+
+// listArg returns argument i of a builtin, which must be a list
+func listArg(name string, args []interface{}, i int) ([]interface{}, error) {
+	list, ok := args[i].([]interface{})
+	if !ok {
+		return nil, &argError{i, fmt.Errorf("%s expects a list, got %s", name, typeName(args[i]))}
+	}
+	return list, nil
+}
+
+// mapArg returns argument i of a builtin, which must be a map
+func mapArg(name string, args []interface{}, i int) (*ordered.Map, error) {
+	m, ok := args[i].(*ordered.Map)
+	if !ok {
+		return nil, &argError{i, fmt.Errorf("%s expects a map, got %s", name, typeName(args[i]))}
+	}
+	return m, nil
+}
+
+// funcArg returns argument i of a builtin, which must be a function
+// or the name of a builtin
+func funcArg(name string, args []interface{}, i int) (interface{}, error) {
+	switch f := args[i].(type) {
+	case *closure:
+		return f, nil
+	case string:
+		if _, ok := builtins[f]; ok {
+			return f, nil
+		}
+	}
+	return nil, &argError{i, fmt.Errorf("%s expects a function, got %s", name, typeName(args[i]))}
+}
+
+// pairFunc checks that argument i of a builtin iterating over a map,
+// its function, takes a key and a value. A function of one parameter
+// would be applied to the key and its result to the value, failing
+// far from the mistake. Builtins are assumed to fit.
+func pairFunc(name string, args []interface{}, i int) error {
+	f, ok := args[i].(*closure)
+	if !ok {
+		return nil
+	}
+	// a curried function, k => v => ..., takes both too
+	params := len(f.fn.Params) - len(f.args)
+	for body := lang.Ungroup(f.fn.Body); params < 2; {
+		fn, ok := body.(*lang.Function)
+		if !ok {
+			return &argError{i, fmt.Errorf("%s over a map expects a function of (key, value), got one of %s", name, arguments(params))}
+		}
+		params += len(fn.Params)
+		body = lang.Ungroup(fn.Body)
+	}
+	return nil
+}
+
+// call applies a function given to a builtin, such as the f of
+// map(xs, f), and returns its result
+func (v *VM) call(f interface{}, args ...interface{}) (interface{}, error) {
+	if err := v.apply(f, args); err != nil {
+		if argErr, ok := err.(*argError); ok {
+			// the argument is one of f's, not of the builtin
+			// calling it
+			return nil, argErr.err
+		}
+		return nil, err
+	}
+	return v.pop(), nil
+}
+
+// predicate calls f for a builtin that needs a boolean from it
+func (v *VM) predicate(name string, f interface{}, args ...interface{}) (bool, error) {
+	val, err := v.call(f, args...)
+	if err != nil {
+		return false, err
+	}
+	b, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s expects its function to return a boolean, got %s", name, typeName(val))
+	}
+	return b, nil
+}
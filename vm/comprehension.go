@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wycleffsean/nostos/lang"
+	"github.com/wycleffsean/nostos/pkg/ordered"
+)
+
+// evalComprehension builds a list, or a map when the comprehension
+// has a key, with an entry for each item of its iterable. Two items
+// with the same key are an error rather than one hiding the other.
+func (v *VM) evalComprehension(node *lang.Comprehension) error {
+	if err := v.evalNode(node.Iterable); err != nil {
+		return err
+	}
+	var keys, items []interface{}
+	switch it := v.pop().(type) {
+	case []interface{}:
+		for i, item := range it {
+			keys = append(keys, float64(i))
+			items = append(items, item)
+		}
+	case *ordered.Map:
+		for _, k := range it.Keys() {
+			item, _ := it.Get(k)
+			keys = append(keys, k)
+			items = append(items, item)
+		}
+	default:
+		return v.wrapError(node.Iterable, fmt.Errorf("cannot iterate over %s", typeName(it)))
+	}
+
+	list := []interface{}{}
+	m := &ordered.Map{}
+	oldEnv := v.env
+	defer func() { v.env = oldEnv }()
+	for i, item := range items {
+		env := make(map[string]interface{}, len(oldEnv)+len(node.Vars))
+		for k, val := range oldEnv {
+			env[k] = val
+		}
+		vals := []interface{}{item}
+		if len(node.Vars) == 2 {
+			vals = []interface{}{keys[i], item}
+		}
+		for j, param := range node.Vars {
+			if err := v.bindParam(param, vals[j], env); err != nil {
+				return iterationError(err, i)
+			}
+		}
+		v.env = env
+		key, val, keep, err := v.evalIteration(node)
+		v.env = oldEnv
+		if err != nil {
+			return iterationError(err, i)
+		}
+		switch {
+		case !keep:
+		case node.Key != nil:
+			if _, dup := m.Get(key); dup {
+				return iterationError(v.wrapError(node.Key, fmt.Errorf("duplicate key %q in map comprehension", key)), i)
+			}
+			m.Set(key, val)
+		default:
+			list = append(list, val)
+		}
+	}
+	if node.Key != nil {
+		v.push(m)
+	} else {
+		v.push(list)
+	}
+	return nil
+}
+
+// evalIteration evaluates the filter, key and value of a
+// comprehension for one item. keep is false when the filter drops
+// the item, or an if without an else omits its value.
+func (v *VM) evalIteration(node *lang.Comprehension) (key string, val interface{}, keep bool, err error) {
+	if node.Filter != nil {
+		if err := v.evalNode(node.Filter); err != nil {
+			return "", nil, false, err
+		}
+		cond := v.pop()
+		keep, ok := cond.(bool)
+		if !ok {
+			return "", nil, false, v.wrapError(node.Filter, fmt.Errorf("comprehension filter must be a boolean, got %s", typeName(cond)))
+		}
+		if !keep {
+			return "", nil, false, nil
+		}
+	}
+	if node.Key != nil {
+		if err := v.evalNode(node.Key); err != nil {
+			return "", nil, false, err
+		}
+		k := v.pop()
+		s, ok := k.(string)
+		if !ok {
+			return "", nil, false, v.wrapError(node.Key, fmt.Errorf("map key must be a string, got %s", typeName(k)))
+		}
+		key = s
+	}
+	present, err := v.evalOptional(node.Value)
+	if err != nil || !present {
+		return "", nil, false, err
+	}
+	return key, v.pop(), true, nil
+}
+
+// iterationError notes which item of a comprehension, counting from
+// one, an error occurred for
+func iterationError(err error, i int) error {
+	var evalErr *EvalError
+	if errors.As(err, &evalErr) {
+		evalErr.Msg = fmt.Sprintf("%s (item %d of the comprehension)", evalErr.Msg, i+1)
+	}
+	return err
+}
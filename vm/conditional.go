@@ -0,0 +1,55 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/wycleffsean/nostos/lang"
+)
+
+// evalCondition evaluates the condition of an if expression
+func (v *VM) evalCondition(node *lang.Conditional) (bool, error) {
+	if err := v.evalNode(node.Cond); err != nil {
+		return false, err
+	}
+	val := v.pop()
+	cond, ok := val.(bool)
+	if !ok {
+		return false, v.wrapError(node.Cond, fmt.Errorf("if condition must be a boolean, got %s", typeName(val)))
+	}
+	return cond, nil
+}
+
+// evalConditional evaluates only the branch selected by the
+// condition. Without an else branch a false condition is null.
+func (v *VM) evalConditional(node *lang.Conditional) error {
+	present, err := v.evalOptional(node)
+	if err != nil {
+		return err
+	}
+	if !present {
+		v.push(nil)
+	}
+	return nil
+}
+
+// evalOptional evaluates a map value or list item, reporting
+// false without pushing anything if an if expression without an
+// else branch omits it
+func (v *VM) evalOptional(n interface{}) (bool, error) {
+	node, ok := lang.Ungroup(n).(*lang.Conditional)
+	if !ok {
+		return true, v.evalNode(n)
+	}
+	cond, err := v.evalCondition(node)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case cond:
+		return v.evalOptional(node.Then)
+	case node.Else != nil:
+		return v.evalOptional(node.Else)
+	default:
+		return false, nil
+	}
+}
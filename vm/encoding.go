@@ -0,0 +1,316 @@
+package vm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	yaml "sigs.k8s.io/yaml/goyaml.v3"
+
+	"github.com/wycleffsean/nostos/pkg/ordered"
+)
+
+// Encoding builtins render maps in the order types.InspectValue
+// does, ordered maps as written and plain maps by sorted key, so a
+// checksum of the output is stable,
+//
+//	checksum/config: sha256(toJson(config.data))
+
+func builtinBase64Encode(v *VM, args ...interface{}) error {
+	strs, err := stringArgs("base64encode", args, 1)
+	if err != nil {
+		return err
+	}
+	v.push(base64.StdEncoding.EncodeToString([]byte(strs[0])))
+	return nil
+}
+
+func builtinBase64Decode(v *VM, args ...interface{}) error {
+	strs, err := stringArgs("base64decode", args, 1)
+	if err != nil {
+		return err
+	}
+	data, err := base64.StdEncoding.DecodeString(strs[0])
+	if err != nil {
+		return &argError{0, fmt.Errorf("base64decode: %v", err)}
+	}
+	v.push(string(data))
+	return nil
+}
+
+// builtinSha256 is the hex encoded SHA-256 digest of a string
+func builtinSha256(v *VM, args ...interface{}) error {
+	strs, err := stringArgs("sha256", args, 1)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(strs[0]))
+	v.push(hex.EncodeToString(sum[:]))
+	return nil
+}
+
+// builtinToJson encodes a value as compact JSON
+func builtinToJson(v *VM, args ...interface{}) error {
+	if err := arity("toJson", args, 1, 1); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := writeJSON(&b, args[0]); err != nil {
+		return &argError{0, fmt.Errorf("toJson: %v", err)}
+	}
+	v.push(b.String())
+	return nil
+}
+
+func writeJSON(b *bytes.Buffer, val interface{}) error {
+	switch val := val.(type) {
+	case *ordered.Map:
+		return writeJSONObject(b, val.Keys(), func(k string) interface{} {
+			child, _ := val.Get(k)
+			return child
+		})
+	case map[string]interface{}:
+		return writeJSONObject(b, sortedKeys(val), func(k string) interface{} { return val[k] })
+	case []interface{}:
+		b.WriteByte('[')
+		for i, item := range val {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeJSON(b, item); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+		return nil
+	case nil, bool, string, float64:
+		if f, ok := val.(float64); ok && (math.IsInf(f, 0) || math.IsNaN(f)) {
+			return fmt.Errorf("cannot encode %v", f)
+		}
+		enc := json.NewEncoder(b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(val); err != nil {
+			return err
+		}
+		b.Truncate(b.Len() - 1) // Encode ends with a newline
+		return nil
+	default:
+		return fmt.Errorf("cannot encode %s", typeName(val))
+	}
+}
+
+func writeJSONObject(b *bytes.Buffer, keys []string, get func(string) interface{}) error {
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := writeJSON(b, k); err != nil {
+			return err
+		}
+		b.WriteByte(':')
+		if err := writeJSON(b, get(k)); err != nil {
+			return err
+		}
+	}
+	b.WriteByte('}')
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// builtinToYaml encodes a value as a YAML document
+func builtinToYaml(v *VM, args ...interface{}) error {
+	if err := arity("toYaml", args, 1, 1); err != nil {
+		return err
+	}
+	out, err := ordered.MarshalYAML(args[0])
+	if err != nil {
+		var unsupported *ordered.UnsupportedValueError
+		if errors.As(err, &unsupported) {
+			err = fmt.Errorf("cannot encode %s", typeName(unsupported.Value))
+		}
+		return &argError{0, fmt.Errorf("toYaml: %v", err)}
+	}
+	v.push(out)
+	return nil
+}
+
+// builtinFromJson decodes JSON, keeping the order of object keys
+func builtinFromJson(v *VM, args ...interface{}) error {
+	strs, err := stringArgs("fromJson", args, 1)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(strings.NewReader(strs[0]))
+	dec.UseNumber()
+	val, err := readJSON(dec)
+	if err == nil {
+		if _, extra := dec.Token(); extra != io.EOF {
+			err = errors.New("unexpected data after the value")
+		}
+	}
+	if err != nil {
+		return &argError{0, fmt.Errorf("fromJson: %v", err)}
+	}
+	v.push(val)
+	return nil
+}
+
+func readJSON(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		switch tok {
+		case '{':
+			m := &ordered.Map{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				val, err := readJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				m.Set(keyTok.(string), val)
+			}
+			_, err := dec.Token() // }
+			return m, err
+		case '[':
+			list := []interface{}{}
+			for dec.More() {
+				val, err := readJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, val)
+			}
+			_, err := dec.Token() // ]
+			return list, err
+		}
+		return nil, fmt.Errorf("unexpected %v", tok)
+	case json.Number:
+		return tok.Float64()
+	default:
+		return tok, nil
+	}
+}
+
+// builtinFromYaml decodes YAML, keeping the order of mapping keys.
+// A stream of several documents decodes to a list of them, the
+// way import reads a multi-document file.
+func builtinFromYaml(v *VM, args ...interface{}) error {
+	strs, err := stringArgs("fromYaml", args, 1)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(strings.NewReader(strs[0]))
+	var docs []interface{}
+	for {
+		var node yaml.Node
+		if err := dec.Decode(&node); err == io.EOF {
+			break
+		} else if err != nil {
+			return &argError{0, fmt.Errorf("fromYaml: %v", err)}
+		}
+		val, err := fromYamlNode(&node)
+		if err != nil {
+			return &argError{0, fmt.Errorf("fromYaml: %v", err)}
+		}
+		docs = append(docs, val)
+	}
+	switch len(docs) {
+	case 0:
+		v.push(nil)
+	case 1:
+		v.push(docs[0])
+	default:
+		v.push(docs)
+	}
+	return nil
+}
+
+func fromYamlNode(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return fromYamlNode(node.Content[0])
+	case yaml.AliasNode:
+		return fromYamlNode(node.Alias)
+	case yaml.SequenceNode:
+		list := []interface{}{}
+		for _, child := range node.Content {
+			val, err := fromYamlNode(child)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, val)
+		}
+		return list, nil
+	case yaml.MappingNode:
+		// a merge key deep merges, the way it does in a Nostos map,
+		// so a document reads the same either way
+		m := &ordered.Map{}
+		var base *ordered.Map
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, child := node.Content[i], node.Content[i+1]
+			val, err := fromYamlNode(child)
+			if err != nil {
+				return nil, err
+			}
+			if key.Tag == "!!merge" {
+				if base != nil {
+					return nil, fmt.Errorf("line %d: duplicate merge key", key.Line)
+				}
+				if base, err = mergeKeyBase(val); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			m.Set(key.Value, val)
+		}
+		if base != nil {
+			return deepMerge(base, m, &defaultMergeOptions, ""), nil
+		}
+		return m, nil
+	default:
+		var val interface{}
+		if err := node.Decode(&val); err != nil {
+			return nil, err
+		}
+		switch n := val.(type) {
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case uint64:
+			return float64(n), nil
+		case float64, string, bool, nil:
+			return n, nil
+		default:
+			// timestamps and binary stay as written
+			return node.Value, nil
+		}
+	}
+}
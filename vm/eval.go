@@ -4,12 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"runtime/debug"
-	"sort"
 	"strings"
 
 	"go.lsp.dev/uri"
 
 	"github.com/wycleffsean/nostos/lang"
+	"github.com/wycleffsean/nostos/pkg/ordered"
 )
 
 type VM struct {
@@ -17,20 +17,24 @@ type VM struct {
 	baseDir string
 	uri     uri.URI
 	env     map[string]interface{}
+	depth   int        // nesting of function calls
+	forcing []*binding // let bindings being evaluated, innermost last
 }
 
 // EvalError represents runtime errors produced during evaluation. It implements
 // lang.NostosError so callers can inspect file position and stack traces.
 type EvalError struct {
-	File     uri.URI
-	Position lang.Position
-	Msg      string
-	Stack    []string
+	File        uri.URI
+	Position    lang.Position
+	EndPosition lang.Position
+	Msg         string
+	Stack       []string
 }
 
 func (e *EvalError) Error() string        { return e.Msg }
 func (e *EvalError) URI() uri.URI         { return e.File }
 func (e *EvalError) Pos() lang.Position   { return e.Position }
+func (e *EvalError) End() lang.Position   { return e.EndPosition }
 func (e *EvalError) StackTrace() []string { return e.Stack }
 
 func newVM(dir string, u uri.URI) *VM {
@@ -43,15 +47,40 @@ func (v *VM) wrapError(n interface{}, err error) error {
 	if _, ok := err.(lang.NostosError); ok {
 		return err
 	}
-	pos := lang.Position{}
+	var pos, end lang.Position
 	if p, ok := n.(interface{ Pos() lang.Position }); ok {
 		pos = p.Pos()
+		end = pos
+	}
+	if e, ok := n.(interface{ End() lang.Position }); ok {
+		end = e.End()
+	}
+	return v.errorSpan(pos, end, err)
+}
+
+// errorAt is like wrapError for errors that belong to a token
+// other than the start of a node, such as an infix operator
+func (v *VM) errorAt(pos lang.Position, err error) error {
+	// tokens found this way are operators and field names,
+	// which are a single line
+	end := lang.Position{
+		ByteOffset:      pos.ByteOffset + pos.ByteLength,
+		LineNumber:      pos.LineNumber,
+		CharacterOffset: pos.CharacterOffset + pos.ByteLength,
+	}
+	return v.errorSpan(pos, end, err)
+}
+
+func (v *VM) errorSpan(pos, end lang.Position, err error) error {
+	if _, ok := err.(lang.NostosError); ok {
+		return err
 	}
 	return &EvalError{
-		File:     v.uri,
-		Position: pos,
-		Msg:      err.Error(),
-		Stack:    strings.Split(string(debug.Stack()), "\n"),
+		File:        v.uri,
+		Position:    pos,
+		EndPosition: end,
+		Msg:         err.Error(),
+		Stack:       strings.Split(string(debug.Stack()), "\n"),
 	}
 }
 
@@ -74,13 +103,13 @@ func (v *VM) peek() interface{} {
 }
 
 // Stack operations
-func (v *VM) createMap()         { v.push(make(map[string]interface{})) }
+func (v *VM) createMap()         { v.push(&ordered.Map{}) }
 func (v *VM) pushKey(key string) { v.push(key) }
 func (v *VM) pushValueToMap() {
 	val := v.pop()
 	key := v.pop().(string)
-	m := v.peek().(map[string]interface{})
-	m[key] = val
+	m := v.peek().(*ordered.Map)
+	m.Set(key, val)
 }
 func (v *VM) createList() { v.push(make([]interface{}, 0)) }
 func (v *VM) appendItem() {
@@ -94,7 +123,23 @@ func Eval(n interface{}) (interface{}, error) {
 	return EvalWithDir(n, ".", uri.URI(""))
 }
 
+// EvalWithDir evaluates a document for output, its value can't
+// hold functions. Paths in it are relative to dir.
 func EvalWithDir(n interface{}, dir string, u uri.URI) (interface{}, error) {
+	val, err := EvalModule(n, dir, u)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRenderable(val, ""); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// EvalModule evaluates a file that may be imported rather than
+// rendered, whose value can hold functions, like a library of
+// helpers
+func EvalModule(n interface{}, dir string, u uri.URI) (interface{}, error) {
 	vm := newVM(dir, u)
 	if err := vm.evalNode(n); err != nil {
 		return nil, err
@@ -106,110 +151,116 @@ func (v *VM) evalNode(n interface{}) error {
 	switch node := n.(type) {
 	case *lang.String:
 		v.push(node.Text)
+	case *lang.Interpolation:
+		return v.evalInterpolation(node)
 	case *lang.Path:
 		v.push(node.Spec)
 	case *lang.Number:
 		v.push(node.Value)
+	case *lang.Boolean:
+		v.push(node.Value)
+	case *lang.Null:
+		v.push(nil)
 	case *lang.Symbol:
 		if val, ok := v.env[node.Text]; ok {
-			v.push(val)
-			break
-		}
-		// TOOD: This is a pretty lame way of dealing with
-		// field access, but gets the job done for now.
-		// Really this should already be present in the AST
-		if strings.Contains(node.Text, ".") {
-			parts := strings.Split(node.Text, ".")
-			cur, ok := v.env[parts[0]]
-			if ok {
-				for _, p := range parts[1:] {
-					m, ok := cur.(map[string]interface{})
-					if !ok {
-						return v.wrapError(node, fmt.Errorf("dot operator requires map"))
-					}
-					val, ok := m[p]
-					if !ok {
-						return v.wrapError(node, fmt.Errorf("unknown field %s", p))
-					}
-					cur = val
+			if b, ok := val.(*binding); ok {
+				forced, err := v.force(b)
+				if err != nil {
+					return err
 				}
-				v.push(cur)
-				break
+				val = forced
 			}
+			v.push(val)
+			break
 		}
 		v.push(node.Text)
+	case *lang.Select:
+		return v.evalSelect(node)
+	case *lang.Index:
+		return v.evalIndex(node)
 	case *lang.List:
 		v.createList()
 		for _, item := range *node {
-			if err := v.evalNode(item); err != nil {
+			present, err := v.evalOptional(item.Value)
+			if err != nil {
+				return err
+			}
+			if present {
+				v.appendItem()
+			}
+		}
+	case *lang.Flow:
+		return v.evalNode(node.Collection)
+	case *lang.Group:
+		return v.evalNode(node.Expr)
+	case *lang.Documents:
+		v.createList()
+		for _, doc := range *node {
+			if err := v.evalNode(doc); err != nil {
 				return err
 			}
 			v.appendItem()
 		}
 	case *lang.Map:
 		v.createMap()
-		keys := make([]lang.Symbol, 0, len(*node))
-		for k := range *node {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].Position.ByteOffset < keys[j].Position.ByteOffset
-		})
-		for _, k := range keys {
-			val := (*node)[k]
+		var base *ordered.Map
+		for _, entry := range *node {
+			k, val := entry.Key, entry.Value
+			if entry.Pattern != nil {
+				return v.wrapError(entry.Pattern, errors.New("patterns can only destructure let bindings and function parameters"))
+			}
+			if k.Text == lang.MergeKey {
+				// YAML allows one merge key, several maps are
+				// merged by listing them
+				if base != nil {
+					return v.wrapError(&entry.Key, errors.New("duplicate merge key, list the maps to merge instead, <<: [a, b]"))
+				}
+				if err := v.evalNode(val); err != nil {
+					return err
+				}
+				b, err := mergeKeyBase(v.pop())
+				if err != nil {
+					return v.wrapError(val, err)
+				}
+				base = b
+				continue
+			}
 			v.pushKey(k.Text)
-			if err := v.evalNode(val); err != nil {
+			present, err := v.evalOptional(val)
+			if err != nil {
 				return err
 			}
+			if !present {
+				v.pop() // the key
+				continue
+			}
 			v.pushValueToMap()
 		}
+		if base != nil {
+			v.push(deepMerge(base, v.pop(), &defaultMergeOptions, ""))
+		}
 	case *lang.Function:
-		return v.wrapError(node, fmt.Errorf("functions not supported in evaluation"))
+		v.push(&closure{fn: node, env: v.env, file: v.uri})
+	case *lang.Pattern:
+		return v.wrapError(node, errors.New("patterns can only destructure let bindings and function parameters"))
 	case *lang.Call:
-		if err := v.evalNode(node.Func); err != nil {
-			return err
-		}
-		fn := v.pop()
-		if err := v.evalNode(node.Arg); err != nil {
-			return err
-		}
-		arg := v.pop()
-		name, ok := fn.(string)
-		if !ok {
-			return v.wrapError(node, fmt.Errorf("function name must be a symbol"))
-		}
-		builtin, ok := builtins[name]
-		if !ok {
-			return v.wrapError(node, fmt.Errorf("unknown builtin %s", name))
-		}
-		if err := builtin(v, arg); err != nil {
-			return v.wrapError(node, err)
-		}
-		return nil
+		return v.evalCall(node)
+	case *lang.BinaryOp:
+		return v.evalBinary(node)
+	case *lang.UnaryOp:
+		return v.evalUnary(node)
 	case *lang.Shovel:
-		return v.wrapError(node, fmt.Errorf("shovel operator not supported in evaluation"))
+		return v.evalShovel(node)
+	case *lang.Conditional:
+		return v.evalConditional(node)
+	case *lang.Comprehension:
+		return v.evalComprehension(node)
 	case *lang.Let:
-		oldEnv := v.env
-		newEnv := make(map[string]interface{})
-		for k, vval := range oldEnv {
-			newEnv[k] = vval
-		}
-		for k, valNode := range *node.Bindings {
-			if err := v.evalNode(valNode); err != nil {
-				return err
-			}
-			newEnv[k.Text] = v.pop()
-		}
-		v.env = newEnv
-		if err := v.evalNode(node.Body); err != nil {
-			return err
-		}
-		result := v.pop()
-		v.env = oldEnv
-		v.push(result)
-		return nil
+		return v.evalLet(node)
 	case *lang.ParseError:
 		return errors.New(node.Error())
+	case *lang.Invalid:
+		return v.evalNode(node.Errors[0])
 	default:
 		return v.wrapError(node, fmt.Errorf("unknown node type %T", node))
 	}
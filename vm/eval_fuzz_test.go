@@ -19,8 +19,8 @@ func FuzzEval(f *testing.F) {
 				t.Fatalf("panic: %v", r)
 			}
 		}()
-		_, items := lang.NewStringLexer(input)
-		p := lang.NewParser(items, uri.URI("fuzz"))
+		lex := lang.NewStringLexer(input)
+		p := lang.NewParser(lex, uri.URI("fuzz"))
 		ast := p.Parse()
 		_, _ = EvalWithDir(ast, ".", uri.URI("fuzz"))
 	})
@@ -7,13 +7,15 @@ import (
 	"testing"
 
 	"go.lsp.dev/uri"
+	"sigs.k8s.io/yaml"
 
 	"github.com/wycleffsean/nostos/lang"
+	"github.com/wycleffsean/nostos/pkg/ordered"
 )
 
 func parse(input string) interface{} {
-	_, items := lang.NewStringLexer(input)
-	p := lang.NewParser(items, uri.URI("test"))
+	lex := lang.NewStringLexer(input)
+	p := lang.NewParser(lex, uri.URI("test"))
 	return p.Parse()
 }
 
@@ -24,11 +26,128 @@ func TestEvalSimpleMap(t *testing.T) {
 		t.Fatalf("eval error: %v", err)
 	}
 	wanted := map[string]interface{}{"foo": float64(1), "bar": "example"}
-	if !reflect.DeepEqual(result, wanted) {
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
 		t.Fatalf("expected %#v got %#v", wanted, result)
 	}
 }
 
+func TestEvalKeepsKeyOrder(t *testing.T) {
+	input := `let
+  base:
+    metadata:
+      name: web
+    replicas: 1
+in
+kind: Service
+apiVersion: v1
+metadata:
+  namespace: prod
+  name: web
+spec: base << {replicas: 2, paused: false}
+`
+	result, err := EvalWithDir(parse(input), ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	m := result.(*ordered.Map)
+	if got := m.Keys(); !reflect.DeepEqual(got, []string{"kind", "apiVersion", "metadata", "spec"}) {
+		t.Errorf("unexpected keys %v", got)
+	}
+	metadata, _ := m.Get("metadata")
+	if got := metadata.(*ordered.Map).Keys(); !reflect.DeepEqual(got, []string{"namespace", "name"}) {
+		t.Errorf("unexpected metadata keys %v", got)
+	}
+	// merged maps keep the base keys first
+	spec, _ := m.Get("spec")
+	if got := spec.(*ordered.Map).Keys(); !reflect.DeepEqual(got, []string{"metadata", "replicas", "paused"}) {
+		t.Errorf("unexpected spec keys %v", got)
+	}
+}
+
+func TestEvalMapEqualityIgnoresKeyOrder(t *testing.T) {
+	result, err := EvalWithDir(parse("x: {a: 1, b: 2} == {b: 2, a: 1}"), ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if x, _ := result.(*ordered.Map).Get("x"); x != true {
+		t.Fatalf("expected maps to be equal got %v", x)
+	}
+}
+
+func TestEvalAnchorsMatchYAML(t *testing.T) {
+	inputs := []string{
+		`defaults: &defaults
+  replicas: 1
+  labels:
+    app: web
+prod:
+  <<: *defaults
+  replicas: 3
+`,
+		`base: &base {cpu: 1, memory: 2}
+extra: &extra {memory: 4, disk: 8}
+both:
+  <<: [*extra, *base]
+  cpu: 2
+`,
+		`ports:
+- &http
+  name: http
+  port: 80
+- *http
+- &http {name: http, port: 8080}
+- *http
+empty: &none
+again: *none
+`,
+	}
+	for _, input := range inputs {
+		var wanted interface{}
+		if err := yaml.Unmarshal([]byte(input), &wanted); err != nil {
+			t.Fatalf("yaml: %v", err)
+		}
+		result, err := EvalWithDir(parse(input), ".", uri.URI("test"))
+		if err != nil {
+			t.Fatalf("%q: eval error: %v", input, err)
+		}
+		if got := ordered.Plain(result); !reflect.DeepEqual(got, wanted) {
+			t.Errorf("%q: expected %#v got %#v", input, wanted, got)
+		}
+	}
+}
+
+func TestEvalAnchorsWithShovel(t *testing.T) {
+	ast := parse(`defaults: &defaults
+  replicas: 1
+  paused: false
+web:
+  name: web
+  <<: *defaults
+  labels: {app: web} << {tier: front}
+api:
+  name: api
+  replicas: 2
+  <<: *defaults
+`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{
+		"defaults": map[string]interface{}{"replicas": float64(1), "paused": false},
+		"web": map[string]interface{}{
+			"name":     "web",
+			"replicas": float64(1),
+			"paused":   false,
+			"labels":   map[string]interface{}{"app": "web", "tier": "front"},
+		},
+		"api": map[string]interface{}{"name": "api", "replicas": float64(2), "paused": false},
+	}
+	if got := ordered.Plain(result); !reflect.DeepEqual(got, wanted) {
+		t.Errorf("expected %#v got %#v", wanted, got)
+	}
+}
+
 func TestEvalLet(t *testing.T) {
 	ast := parse("let foo: 1 in foo")
 	result, err := EvalWithDir(ast, ".", uri.URI("test"))
@@ -40,6 +159,112 @@ func TestEvalLet(t *testing.T) {
 	}
 }
 
+func TestEvalLetBindingsInAnyOrder(t *testing.T) {
+	tests := []struct {
+		input  string
+		wanted interface{}
+	}{
+		{"let\n  svcPort: port\n  port: 80\nin svcPort", float64(80)},
+		{"let\n  a: b + 1\n  b: c * 2\n  c: 3\nin a", float64(7)},
+		{"let\n  x: double(n)\n  double: m => m * 2\n  n: 4\nin x", float64(8)},
+		{"let\n  x: double(n)\n  double: (m => m * 2)\n  n: 4\nin x", float64(8)},
+		{"let\n  port: 80\n  svc: {port: port}\nin svc.port", float64(80)},
+		{"let x: 1 in let\n  y: x\n  x: 2\nin y", float64(2)},
+		{"let {a: b, b: 2} in a", float64(2)},
+	}
+	for _, tt := range tests {
+		result, err := EvalWithDir(parse(tt.input), ".", uri.URI("test"))
+		if err != nil {
+			t.Fatalf("%q: eval error: %v", tt.input, err)
+		}
+		if result != tt.wanted {
+			t.Errorf("%q: expected %v got %#v", tt.input, tt.wanted, result)
+		}
+	}
+}
+
+func TestEvalLetErrors(t *testing.T) {
+	evalErrorCases(t, ".", []evalErrorCase{
+		{"let\n  a: b + 1\n  b: c\n  c: a\nin a", "let bindings form a cycle: a -> b -> c -> a", 1, 2},
+		{"let\n  a: 1\n  b: c\n  c: b\nin a", "let bindings form a cycle: b -> c -> b", 2, 2},
+		{"let x: x in x", "let bindings form a cycle: x -> x", 0, 4},
+		{"let\n  a: f(1)\n  f: n => a\nin a", "let bindings form a cycle: a -> a", 1, 2},
+		// unused bindings are still evaluated
+		{"let\n  unused: 1 / 0\nin 2", "division by zero", 1, 12},
+	})
+}
+
+func TestEvalDestructuring(t *testing.T) {
+	tests := []struct {
+		input  string
+		wanted interface{}
+	}{
+		{"let\n  {image, tag}: {image: nginx, tag: \"1.25\"}\nin \"${image}:${tag}\"", "nginx:1.25"},
+		{"let\n  app: {container: {image: nginx}}\n  {image, tag = latest}: app.container\nin \"${image}:${tag}\"", "nginx:latest"},
+		{"let\n  {port, target = port}: {port: 80}\nin target", float64(80)},
+		{"let\n  port: target\n  {target}: {target: 8080}\nin port", float64(8080)},
+		{"let\n  f: {name, port = 80} => \"${name}:${port}\"\nin f({name: web})", "web:80"},
+		{"let\n  f: ({a}, b, {c = 3}) => a + b + c\nin f({a: 1}, 2, {})", float64(6)},
+	}
+	for _, tt := range tests {
+		result, err := EvalWithDir(parse(tt.input), ".", uri.URI("test"))
+		if err != nil {
+			t.Fatalf("%q: eval error: %v", tt.input, err)
+		}
+		if result != tt.wanted {
+			t.Errorf("%q: expected %v got %#v", tt.input, tt.wanted, result)
+		}
+	}
+}
+
+func TestEvalDestructuringErrors(t *testing.T) {
+	evalErrorCases(t, ".", []evalErrorCase{
+		{"let\n  {image, tag}: {image: nginx}\nin image", `unknown field "tag", available fields: image`, 1, 10},
+		{"let\n  {image}: 3\nin image", "cannot destructure number, expected a map", 1, 2},
+		{"let\n  f: {name, port} => name\nin f({port: 80})", `unknown field "name", available fields: port`, 1, 6},
+		{"let\n  f: (a, {b}) => b\nin f(1, [])", "cannot destructure list, expected a map", 1, 9},
+		{"x: {a, b}", "patterns can only destructure let bindings and function parameters", 0, 3},
+	})
+}
+
+func TestEvalComprehensions(t *testing.T) {
+	tests := []struct {
+		input  string
+		wanted interface{}
+	}{
+		{"[for x in [1, 2, 3]: x * 2]", []interface{}{float64(2), float64(4), float64(6)}},
+		{"[for x in [1, 2, 3, 4] if x % 2 == 0: x]", []interface{}{float64(2), float64(4)}},
+		{"[for i, x in [a, b]: \"${i}=${x}\"]", []interface{}{"0=a", "1=b"}},
+		{"[for x in {a: 1, b: 2}: x]", []interface{}{float64(1), float64(2)}},
+		{"[for x in [1, 2]: if x > 1 then x]", []interface{}{float64(2)}},
+		{"[for x in [1, 2]: (if x > 1 then x)]", []interface{}{float64(2)}},
+		{"[for {name} in [{name: a}, {name: b}]: name]", []interface{}{"a", "b"}},
+		{"{for k, v in {app: web}: \"example.com/${k}\": v}", map[string]interface{}{"example.com/app": "web"}},
+		{"{for t in [{name: a, port: 80}]: t.name: t.port}", map[string]interface{}{"a": float64(80)}},
+		{"[for x in []: x]", []interface{}{}},
+	}
+	for _, tt := range tests {
+		result, err := EvalWithDir(parse(tt.input), ".", uri.URI("test"))
+		if err != nil {
+			t.Fatalf("%q: eval error: %v", tt.input, err)
+		}
+		if got := ordered.Plain(result); !reflect.DeepEqual(got, tt.wanted) {
+			t.Errorf("%q: expected %#v got %#v", tt.input, tt.wanted, got)
+		}
+	}
+}
+
+func TestEvalComprehensionErrors(t *testing.T) {
+	evalErrorCases(t, ".", []evalErrorCase{
+		{"[for x in 3: x]", "cannot iterate over number", 0, 10},
+		{"[for x in [1, 2]:\n  x / (x - 2)]", "division by zero (item 2 of the comprehension)", 1, 4},
+		{"[for x in [1] if x: x]", "comprehension filter must be a boolean, got number (item 1 of the comprehension)", 0, 17},
+		{"{for x in [1]: x: x}", "map key must be a string, got number (item 1 of the comprehension)", 0, 15},
+		{"{for x in [a, b, a]: x: 1}", `duplicate key "a" in map comprehension (item 3 of the comprehension)`, 0, 21},
+		{"[for {a} in [{b: 1}]: a]", `unknown field "a", available fields: b (item 1 of the comprehension)`, 0, 6},
+	})
+}
+
 func TestEvalDot(t *testing.T) {
 	ast := parse("let\n  foo:\n    bar: 1\nin\nfoo.bar")
 	result, err := EvalWithDir(ast, ".", uri.URI("test"))
@@ -58,8 +283,8 @@ func TestEvalOdysseyExample(t *testing.T) {
 		t.Fatalf("read example: %v", err)
 	}
 
-	_, items := lang.NewStringLexer(string(data))
-	p := lang.NewParser(items, uri.File(path))
+	lex := lang.NewStringLexer(string(data))
+	p := lang.NewParser(lex, uri.File(path))
 	ast := p.Parse()
 
 	got, err := EvalWithDir(ast, filepath.Dir(path), uri.File(path))
@@ -72,8 +297,8 @@ func TestEvalOdysseyExample(t *testing.T) {
 	if err != nil {
 		t.Fatalf("read service: %v", err)
 	}
-	_, items = lang.NewStringLexer(string(svcData))
-	p = lang.NewParser(items, uri.File(svcPath))
+	lex = lang.NewStringLexer(string(svcData))
+	p = lang.NewParser(lex, uri.File(svcPath))
 	svcAST := p.Parse()
 	svcObj, err := EvalWithDir(svcAST, filepath.Dir(svcPath), uri.File(svcPath))
 	if err != nil {
@@ -85,8 +310,8 @@ func TestEvalOdysseyExample(t *testing.T) {
 	if err != nil {
 		t.Fatalf("read deployment: %v", err)
 	}
-	_, items = lang.NewStringLexer(string(depData))
-	p = lang.NewParser(items, uri.File(depPath))
+	lex = lang.NewStringLexer(string(depData))
+	p = lang.NewParser(lex, uri.File(depPath))
 	depAST := p.Parse()
 	depObj, err := EvalWithDir(depAST, filepath.Dir(depPath), uri.File(depPath))
 	if err != nil {
@@ -99,7 +324,503 @@ func TestEvalOdysseyExample(t *testing.T) {
 		},
 	}
 
-	if !reflect.DeepEqual(got, want) {
+	if !reflect.DeepEqual(ordered.Plain(got), ordered.Plain(want)) {
 		t.Fatalf("unexpected eval result: %#v", got)
 	}
 }
+
+func TestEvalBoolAndNull(t *testing.T) {
+	ast := parse("hostNetwork: true\nreadOnly: FALSE\nselector: null")
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{"hostNetwork": true, "readOnly": false, "selector": nil}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalOperators(t *testing.T) {
+	ast := parse(`let
+  base:
+    replicas: 3
+    port: 8080
+  name: "web"
+in
+replicas: base.replicas * 2 - 1
+port: base.port + 1
+ratio: (1 + 2) * 3 / 2
+rem: 7 % 4
+neg: -base.replicas
+diff: base.replicas -1
+label: name + "-svc"
+big: base.replicas >= 3 && !(name == "db")
+small: base.replicas < 2 || false
+same: name != "web"`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{
+		"replicas": float64(5),
+		"port":     float64(8081),
+		"ratio":    float64(4.5),
+		"rem":      float64(3),
+		"neg":      float64(-3),
+		"diff":     float64(2),
+		"label":    "web-svc",
+		"big":      true,
+		"small":    false,
+		"same":     false,
+	}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalShortCircuit(t *testing.T) {
+	// the right operand would fail if evaluated
+	ast := parse("a: false && 1 / 0 == 1\nb: true || \"x\" - 1")
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{"a": false, "b": true}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalOperatorErrors(t *testing.T) {
+	evalErrorCases(t, ".", []evalErrorCase{
+		{"port: \"80\" - 1", "operator - is not defined on string and number", 0, 11},
+		{"x: 1 / 0", "division by zero", 0, 5},
+		{"x: 1 && true", "operator && requires booleans, got number", 0, 5},
+		{"x: !1", "operator ! requires a boolean, got number", 0, 3},
+	})
+}
+
+func TestEvalErrorSpans(t *testing.T) {
+	tests := []struct {
+		input      string
+		start, end uint
+	}{
+		{"x: 1 / 0", 5, 6},
+		{"x: 1 && true", 5, 7},
+		{"x: nope(1)", 3, 10},
+		{"x: {a: 1}.b", 10, 11},
+	}
+	for _, tt := range tests {
+		_, err := EvalWithDir(parse(tt.input), ".", uri.URI("test"))
+		evalErr, ok := err.(*EvalError)
+		if !ok {
+			t.Fatalf("%q: expected EvalError got %T %v", tt.input, err, err)
+		}
+		if evalErr.Pos().CharacterOffset != tt.start || evalErr.End().CharacterOffset != tt.end {
+			t.Errorf("%q: expected span %d-%d got %d-%d", tt.input, tt.start, tt.end,
+				evalErr.Pos().CharacterOffset, evalErr.End().CharacterOffset)
+		}
+	}
+}
+
+func TestEvalInterpolation(t *testing.T) {
+	ast := parse(`let
+  app:
+    name: "web"
+    port: 8080
+  registry: "ghcr.io"
+in
+name: "${app.name}-svc"
+image: "${registry}/${app.name}:v${1.5 * 2}"
+url: "http://${app.name}:${app.port + 1}/"
+debug: "${app.port > 80}"`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{
+		"name":  "web-svc",
+		"image": "ghcr.io/web:v3",
+		"url":   "http://web:8081/",
+		"debug": "true",
+	}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalInterpolationErrors(t *testing.T) {
+	evalErrorCases(t, ".", []evalErrorCase{
+		{`x: "a ${"b" - 1}"`, "operator - is not defined on string and number", 0, 12},
+		{"let m:\n  a: 1\nin\nx: \"a ${m}\"", "cannot interpolate map into a string", 3, 8},
+		{`x: "${null}"`, "cannot interpolate null into a string", 0, 6},
+	})
+}
+
+func TestEvalBlockScalar(t *testing.T) {
+	ast := parse(`let
+  workers: 4
+in
+data:
+  nginx.conf: |
+    worker_processes ${workers};
+    events {
+      worker_connections ${workers * 256};
+    }
+  motd: >-
+    hello
+    world`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{
+		"data": map[string]interface{}{
+			"nginx.conf": "worker_processes 4;\nevents {\n  worker_connections 1024;\n}\n",
+			"motd":       "hello world",
+		},
+	}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalFlowCollections(t *testing.T) {
+	ast := parse(`let
+  port: 6379
+in
+containers:
+- name: redis
+  args: ["--port", "${port}"]
+  ports: [{containerPort: port, protocol: TCP}]
+selector:
+  matchLabels: {app: redis, tier: cache}`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{
+		"containers": []interface{}{
+			map[string]interface{}{
+				"name":  "redis",
+				"args":  []interface{}{"--port", "6379"},
+				"ports": []interface{}{map[string]interface{}{"containerPort": float64(6379), "protocol": "TCP"}},
+			},
+		},
+		"selector": map[string]interface{}{
+			"matchLabels": map[string]interface{}{"app": "redis", "tier": "cache"},
+		},
+	}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalConditional(t *testing.T) {
+	ast := parse(`let
+  env: "prod"
+  debug: false
+in
+replicas: if env == "prod" then 3 else 1
+tier: if env == "dev" then "dev" else if env == "prod" then "prod" else "other"
+lazy: if true then "ok" else 1 / 0
+logLevel: if debug then "debug"
+tls:
+  if env == "prod"
+  then
+    secretName: web-tls
+args:
+- "--verbose"
+- if debug then "--trace"
+- if !debug then "--quiet"`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{
+		"replicas": float64(3),
+		"tier":     "prod",
+		"lazy":     "ok",
+		"tls":      map[string]interface{}{"secretName": "web-tls"},
+		"args":     []interface{}{"--verbose", "--quiet"},
+	}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalConditionalWithoutElse(t *testing.T) {
+	// outside of a map or list a missing else branch is null
+	result, err := EvalWithDir(parse("if false then 1"), ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if result != nil {
+		t.Fatalf("expected nil got %#v", result)
+	}
+}
+
+func TestEvalConditionalNonBoolean(t *testing.T) {
+	evalErrorCases(t, ".", []evalErrorCase{
+		{"replicas: if env then 3 else 1", "if condition must be a boolean, got string", 0, 13},
+	})
+}
+
+func TestEvalClosures(t *testing.T) {
+	ast := parse(`let
+  namespace: "web"
+  mkService: app =>
+    apiVersion: v1
+    kind: Service
+    metadata:
+      name: "${app.name}-svc"
+      namespace: namespace
+    spec:
+      ports: [{port: app.port}]
+  add: (a, b) => a + b
+  curried: a => b => a * b
+  double: curried(2)
+  fact: n => if n <= 1 then 1 else n * fact(n - 1)
+  isEven: n => if n == 0 then true else isOdd(n - 1)
+  isOdd: n => if n == 0 then false else isEven(n - 1)
+in
+svc: mkService({name: redis, port: 6379})
+sum: add(1, 2)
+product: curried(3)(4)
+uncurried: curried(3, 4)
+partial: add(10)(5)
+doubled: double(21)
+fact: fact(5)
+even: isEven(10)
+inline: (x => x + 1)(1)`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{
+		"svc": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "redis-svc", "namespace": "web"},
+			"spec":       map[string]interface{}{"ports": []interface{}{map[string]interface{}{"port": float64(6379)}}},
+		},
+		"sum":       float64(3),
+		"product":   float64(12),
+		"uncurried": float64(12),
+		"partial":   float64(15),
+		"doubled":   float64(42),
+		"fact":      float64(120),
+		"even":      true,
+		"inline":    float64(2),
+	}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalClosureCapturesDefiningScope(t *testing.T) {
+	// x inside the function is the x where it was defined,
+	// not the x where it is called
+	ast := parse(`let
+  x: 1
+  f: y => x + y
+in
+let
+  x: 100
+in
+f(2)`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if result != float64(3) {
+		t.Fatalf("expected 3 got %#v", result)
+	}
+}
+
+func TestEvalCallErrors(t *testing.T) {
+	evalErrorCases(t, ".", []evalErrorCase{
+		{"let n: 1 in n(2)", "number is not a function", 0, 12},
+		{"x: nope(1)", "unknown function nope", 0, 3},
+		{"let f: x => x(1) in f(2)", "number is not a function", 0, 12},
+		{"let loop: n => loop(n + 1) in loop(0)", "maximum call depth of 10000 exceeded", 0, 15},
+		{"let f: x => x in r: f()", "function expects 1 argument, got none", 0, 20},
+		{"let f: x => y => x in r: f(1)()", "function expects 1 argument, got none", 0, 25},
+		// functions can't be rendered, the error is at the definition
+		{"let f: x => x in f", "cannot render the document, it's a function", 0, 7},
+		{"let f: x => y => x in r: {a: [f(1)]}", "cannot render r.a[0], it's a function", 0, 12},
+		{"let f: (x, y) => x in r: f(1)", "cannot render r, it's a function awaiting 1 argument", 0, 7},
+	})
+}
+
+func TestEvalSelectAndIndex(t *testing.T) {
+	ast := parse(`let
+  app:
+    name: redis
+    ports: [{name: tcp, port: 6379}, {name: metrics, port: 9121}]
+    labels: {app.kubernetes.io/name: redis}
+  mk: x => {value: x}
+in
+first: app.ports[0].port
+second: app.ports[1]["name"]
+label: app.labels["app.kubernetes.io/name"]
+call: mk(1).value
+image: nginx.io/nginx
+version: v1.2.3`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{
+		"first":   float64(6379),
+		"second":  "metrics",
+		"label":   "redis",
+		"call":    float64(1),
+		"image":   "nginx.io/nginx",
+		"version": "v1.2.3",
+	}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalSelectAndIndexErrors(t *testing.T) {
+	app := "let\n  app:\n    name: redis\n    port: 1\n    ports: [1]\nin\n"
+	evalErrorCases(t, ".", []evalErrorCase{
+		{app + "x: app.nmae", `unknown field "nmae", available fields: name, port, ports`, 6, 7},
+		{app + "x: app.name.first", "cannot select field first from string", 6, 12},
+		{app + "x: app.ports[3]", "index 3 out of range for list of length 1", 6, 13},
+		{app + "x: app.ports[0.5]", "list index must be a whole number, got number", 6, 13},
+		{app + "x: app[\"nope\"]", `unknown key "nope", available keys: name, port, ports`, 6, 7},
+		{app + "x: app.port[0]", "cannot index number", 6, 11},
+	})
+}
+
+func TestEvalShovelDeepMerge(t *testing.T) {
+	ast := parse(`let
+  base:
+    replicas: 1
+    labels: {app: web, tier: front}
+    ports: [80]
+in
+x: base << {replicas: 2, labels: {tier: back}, ports: [443]}
+base: base`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{
+		"x": map[string]interface{}{
+			"replicas": float64(2),
+			"labels":   map[string]interface{}{"app": "web", "tier": "back"},
+			"ports":    []interface{}{float64(443)},
+		},
+		// the operands aren't modified
+		"base": map[string]interface{}{
+			"replicas": float64(1),
+			"labels":   map[string]interface{}{"app": "web", "tier": "front"},
+			"ports":    []interface{}{float64(80)},
+		},
+	}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalMergeListStrategies(t *testing.T) {
+	ast := parse(`let
+  base:
+    args: [a]
+    containers: [{name: app, image: app-1}, {name: sidecar, image: proxy-1}]
+  over:
+    args: [b]
+    containers: [{name: app, image: app-2}, {name: debug, image: busybox}]
+in
+replace: merge(base, over)
+append: merge(base, over, {lists: append})
+byName: merge(base, over, {lists: merge})
+fields: merge(base, over, {lists: merge, fields: {args: append}})`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	container := func(name, image string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "image": image}
+	}
+	merged := []interface{}{container("app", "app-2"), container("sidecar", "proxy-1"), container("debug", "busybox")}
+	wanted := map[string]interface{}{
+		"replace": map[string]interface{}{
+			"args":       []interface{}{"b"},
+			"containers": []interface{}{container("app", "app-2"), container("debug", "busybox")},
+		},
+		"append": map[string]interface{}{
+			"args": []interface{}{"a", "b"},
+			"containers": []interface{}{
+				container("app", "app-1"), container("sidecar", "proxy-1"),
+				container("app", "app-2"), container("debug", "busybox"),
+			},
+		},
+		"byName": map[string]interface{}{
+			"args":       []interface{}{"a", "b"},
+			"containers": merged,
+		},
+		"fields": map[string]interface{}{
+			"args":       []interface{}{"a", "b"},
+			"containers": merged,
+		},
+	}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalMergeKey(t *testing.T) {
+	ast := parse(`let
+  base: {replicas: 1, labels: {app: web}}
+  extra: {replicas: 3, paused: false}
+in
+block:
+  <<: base
+  replicas: 2
+  labels:
+    tier: front
+flow: {<<: base, replicas: 4}
+many:
+  <<: [base, extra]`)
+	result, err := EvalWithDir(ast, ".", uri.URI("test"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	wanted := map[string]interface{}{
+		"block": map[string]interface{}{
+			"replicas": float64(2),
+			"labels":   map[string]interface{}{"app": "web", "tier": "front"},
+		},
+		"flow": map[string]interface{}{
+			"replicas": float64(4),
+			"labels":   map[string]interface{}{"app": "web"},
+		},
+		// earlier maps take precedence
+		"many": map[string]interface{}{
+			"replicas": float64(1),
+			"labels":   map[string]interface{}{"app": "web"},
+			"paused":   false,
+		},
+	}
+	if !reflect.DeepEqual(ordered.Plain(result), wanted) {
+		t.Fatalf("expected %#v got %#v", wanted, result)
+	}
+}
+
+func TestEvalMergeErrors(t *testing.T) {
+	evalErrorCases(t, ".", []evalErrorCase{
+		{"x: {a: 1} << 2", "operator << requires maps, got map and number", 0, 10},
+		{"x: {<<: 1}", "merge key value must be a map or a list of maps, got number", 0, 8},
+		{"x: {<<: [{a: 1}, 2]}", "merge key list items must be maps, got number", 0, 8},
+		{"x:\n  <<: {a: 1}\n  b: 2\n  <<: {c: 3}", "duplicate merge key, list the maps to merge instead, <<: [a, b]", 3, 2},
+		{"x: merge({}, {}, {lists: zip})", "unknown list strategy zip, expected replace, append or merge", 0, 3},
+		{"x: merge({}, {}, {depth: 1})", "unknown merge option depth", 0, 3},
+		{"x: merge({}, [])", "merge expects maps, got map and list", 0, 3},
+	})
+}
@@ -0,0 +1,208 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wycleffsean/nostos/pkg/ordered"
+	"github.com/wycleffsean/nostos/pkg/urispec"
+	"github.com/wycleffsean/nostos/pkg/workspace"
+)
+
+// File builtins read data into a program, for generating a
+// ConfigMap from files the way kustomize does,
+//
+//	data: readDir(./dashboards)
+//
+// Their argument is a path or spec like import's. A git spec names
+// the root of its repository and takes a second argument, the path
+// of a file within it, readFile(github:org/charts, "nginx.conf").
+// A path can only name a file in the workspace, or in the
+// repository of a module imported from one, and a git spec one in
+// that repository, so a program evaluates the same wherever it's
+// run. File builtins never clone, a repository must have been
+// cloned already, by importing it.
+
+func builtinReadFile(v *VM, args ...interface{}) error {
+	path, _, err := v.filePath("readFile", args)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return &argError{0, fmt.Errorf("readFile: %v", err)}
+	}
+	v.push(string(data))
+	return nil
+}
+
+// builtinReadLines reads a file as a list of its lines, without
+// their line endings
+func builtinReadLines(v *VM, args ...interface{}) error {
+	path, _, err := v.filePath("readLines", args)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return &argError{0, fmt.Errorf("readLines: %v", err)}
+	}
+	lines := []interface{}{}
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		v.push(lines)
+		return nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		lines = append(lines, strings.TrimSuffix(line, "\r"))
+	}
+	v.push(lines)
+	return nil
+}
+
+// builtinReadDir reads the files of a directory into a map of file
+// name to contents, in name order. Subdirectories are left out, and
+// so are links to files outside the sandbox, the way a directory
+// copied out of it would leave them dangling.
+func builtinReadDir(v *VM, args ...interface{}) error {
+	dir, root, err := v.filePath("readDir", args)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return &argError{0, fmt.Errorf("readDir: %v", err)}
+	}
+	files := &ordered.Map{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		// a link is read from where it points
+		path, err := root.resolve(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return &argError{0, fmt.Errorf("readDir: %v", err)}
+		}
+		files.Set(entry.Name(), string(data))
+	}
+	v.push(files)
+	return nil
+}
+
+// filePath resolves the arguments of a file builtin to a path in
+// the sandbox, returning the sandbox too
+func (v *VM) filePath(name string, args []interface{}) (string, sandbox, error) {
+	if err := arity(name, args, 1, 2); err != nil {
+		return "", sandbox{}, err
+	}
+	var spec urispec.Spec
+	switch arg := args[0].(type) {
+	case urispec.Spec:
+		spec = arg
+	case string:
+		spec = urispec.Parse(arg)
+	default:
+		return "", sandbox{}, &argError{0, fmt.Errorf("%s expects a path, got %s", name, typeName(arg))}
+	}
+	root, err := v.sandboxFor(spec)
+	if err != nil {
+		return "", sandbox{}, &argError{0, fmt.Errorf("%s: %v", name, err)}
+	}
+	path := root.dir
+	if spec.Type == "path" {
+		path = spec.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(v.baseDir, path)
+		}
+	}
+	last := 0
+	if len(args) == 2 {
+		sub, err := stringArg(name, args, 1)
+		if err != nil {
+			return "", sandbox{}, err
+		}
+		if filepath.IsAbs(sub) {
+			return "", sandbox{}, &argError{1, fmt.Errorf("%s expects a path relative to %s, got %s", name, spec.Raw, sub)}
+		}
+		path = filepath.Join(path, sub)
+		last = 1
+	}
+	resolved, err := root.resolve(path)
+	if err != nil {
+		return "", sandbox{}, &argError{last, fmt.Errorf("%s: %v", name, err)}
+	}
+	return resolved, root, nil
+}
+
+// sandbox is the directory a file builtin may read from
+type sandbox struct {
+	dir  string
+	name string // how errors refer to it
+}
+
+// sandboxFor returns the directory the files named by spec must be
+// in. For a git spec it's the repository's clone, which must already
+// exist. For a path it's the workspace, or the repository of a
+// module imported from one.
+func (v *VM) sandboxFor(spec urispec.Spec) (sandbox, error) {
+	switch spec.Type {
+	case "path":
+	case "git":
+		dir := spec.ClonePath()
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return sandbox{}, fmt.Errorf("%s has not been cloned, import it first", spec.Raw)
+		}
+		return sandbox{dir, spec.Raw}, nil
+	default:
+		return sandbox{}, fmt.Errorf("unknown spec type %s", spec.Type)
+	}
+	if base, err := filepath.Abs(v.baseDir); err == nil {
+		if rel, ok := within(urispec.CacheDir(), base); ok && rel != "." {
+			repo := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+			return sandbox{filepath.Join(urispec.CacheDir(), repo), "the repository"}, nil
+		}
+	}
+	return sandbox{workspace.Dir(), "the workspace"}, nil
+}
+
+// resolve resolves the links in path and checks that the file it
+// names is in the sandbox. A missing file outside it is reported
+// as outside, not as missing.
+func (s sandbox) resolve(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, linkErr := filepath.EvalSymlinks(abs)
+	if linkErr != nil {
+		resolved = abs
+	}
+	roots := []string{s.dir}
+	if real, err := filepath.EvalSymlinks(s.dir); err == nil && real != s.dir {
+		roots = append(roots, real)
+	}
+	for _, root := range roots {
+		if _, ok := within(root, resolved); ok {
+			return resolved, linkErr
+		}
+	}
+	return "", fmt.Errorf("%s is outside %s", path, s.name)
+}
+
+// within returns path relative to dir when it's inside it
+func within(dir, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
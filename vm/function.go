@@ -0,0 +1,103 @@
+package vm
+
+import (
+	"fmt"
+
+	"go.lsp.dev/uri"
+
+	"github.com/wycleffsean/nostos/lang"
+)
+
+// maxCallDepth bounds recursion so that a runaway recursive
+// function is reported as an error rather than exhausting the stack
+const maxCallDepth = 10000
+
+// closure is a function value. It captures the environment the
+// function was created in, along with any arguments already
+// applied to it when it is called with fewer than it takes.
+type closure struct {
+	fn   *lang.Function
+	env  map[string]interface{}
+	args []interface{}
+	file uri.URI // where the function is defined
+}
+
+func (v *VM) evalCall(node *lang.Call) error {
+	if err := v.evalNode(node.Func); err != nil {
+		return err
+	}
+	fn := v.pop()
+	args := make([]interface{}, 0, len(node.Args))
+	for _, arg := range node.Args {
+		if err := v.evalNode(arg); err != nil {
+			return err
+		}
+		args = append(args, v.pop())
+	}
+	if err := v.apply(fn, args); err != nil {
+		if argErr, ok := err.(*argError); ok && argErr.index < len(node.Args) {
+			return v.wrapError(node.Args[argErr.index], argErr.err)
+		}
+		return v.wrapError(node, err)
+	}
+	return nil
+}
+
+// apply calls fn, a closure or the name of a builtin, with args
+// and pushes the result
+func (v *VM) apply(fn interface{}, args []interface{}) error {
+	switch f := fn.(type) {
+	case *closure:
+		return v.applyClosure(f, args)
+	case string:
+		builtin, ok := builtins[f]
+		if !ok {
+			return fmt.Errorf("unknown function %s", f)
+		}
+		return builtin(v, args...)
+	default:
+		return fmt.Errorf("%s is not a function", typeName(fn))
+	}
+}
+
+// applyClosure evaluates the body of a closure once it has all of
+// its arguments. Given fewer it returns a closure awaiting the rest,
+// given more the result of the body is applied to the remainder.
+func (v *VM) applyClosure(f *closure, args []interface{}) error {
+	bound := append(append([]interface{}{}, f.args...), args...)
+	params := f.fn.Params
+	if len(bound) < len(params) {
+		if len(args) == 0 {
+			return fmt.Errorf("function expects %s, got none", arguments(len(params)-len(f.args)))
+		}
+		v.push(&closure{fn: f.fn, env: f.env, args: bound, file: f.file})
+		return nil
+	}
+	if v.depth >= maxCallDepth {
+		return fmt.Errorf("maximum call depth of %d exceeded", maxCallDepth)
+	}
+
+	env := make(map[string]interface{}, len(f.env)+len(params))
+	for k, val := range f.env {
+		env[k] = val
+	}
+	for i, param := range params {
+		if err := v.bindParam(param, bound[i], env); err != nil {
+			return err
+		}
+	}
+	oldEnv := v.env
+	v.env = env
+	v.depth++
+	err := v.evalNode(f.fn.Body)
+	v.depth--
+	v.env = oldEnv
+	if err != nil {
+		return err
+	}
+
+	if rest := bound[len(params):]; len(rest) > 0 {
+		return v.apply(v.pop(), rest)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wycleffsean/nostos/lang"
+)
+
+func (v *VM) evalInterpolation(node *lang.Interpolation) error {
+	var b strings.Builder
+	for _, part := range node.Parts {
+		if s, ok := part.(*lang.String); ok {
+			b.WriteString(s.Text)
+			continue
+		}
+		if err := v.evalNode(part); err != nil {
+			return err
+		}
+		val := v.pop()
+		s, ok := interpolate(val)
+		if !ok {
+			return v.errorAt(part.Pos(), fmt.Errorf("cannot interpolate %s into a string", typeName(val)))
+		}
+		b.WriteString(s)
+	}
+	v.push(b.String())
+	return nil
+}
+
+// interpolate renders a scalar for inclusion in a string. Numbers
+// use the shortest representation that round trips, so 8080 is
+// "8080" rather than "8080.000000" or "8.08e+03".
+func interpolate(val interface{}) (string, bool) {
+	switch val := val.(type) {
+	case string:
+		return val, true
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(val), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,133 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wycleffsean/nostos/lang"
+)
+
+// binding is a let binding evaluated the first time it's needed,
+// so bindings can refer to one another in any order. A name bound
+// by a pattern takes its field of the source binding instead.
+type binding struct {
+	key     lang.Symbol
+	node    interface{}
+	env     map[string]interface{}
+	pending bool // being evaluated, seeing it again is a cycle
+	done    bool
+	value   interface{}
+
+	source  *binding
+	pattern *lang.Pattern
+	field   *lang.PatternField
+}
+
+func (v *VM) evalLet(node *lang.Let) error {
+	oldEnv := v.env
+	env := make(map[string]interface{}, len(oldEnv)+len(*node.Bindings))
+	for k, val := range oldEnv {
+		env[k] = val
+	}
+	// every binding sees every other one. Functions close over
+	// the environment so they can call themselves and each other,
+	// other values are evaluated when they're first referenced
+	for _, entry := range *node.Bindings {
+		if entry.Pattern != nil {
+			source := &binding{key: entry.Key, node: entry.Value, env: env}
+			for _, field := range entry.Pattern.Fields {
+				env[field.Name.Text] = &binding{key: field.Name, env: env, source: source, pattern: entry.Pattern, field: field}
+			}
+			continue
+		}
+		if fn, ok := lang.Ungroup(entry.Value).(*lang.Function); ok {
+			env[entry.Key.Text] = &closure{fn: fn, env: env, file: v.uri}
+			continue
+		}
+		env[entry.Key.Text] = &binding{key: entry.Key, node: entry.Value, env: env}
+	}
+	// bindings the body doesn't use are still evaluated, in the
+	// order they're written, so their errors are reported
+	for _, entry := range *node.Bindings {
+		names := []string{entry.Key.Text}
+		if entry.Pattern != nil {
+			names = names[:0]
+			for _, field := range entry.Pattern.Fields {
+				names = append(names, field.Name.Text)
+			}
+		}
+		for _, name := range names {
+			if b, ok := env[name].(*binding); ok {
+				if _, err := v.force(b); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	v.env = env
+	err := v.evalNode(node.Body)
+	v.env = oldEnv
+	return err
+}
+
+// force returns the value of a binding, evaluating it if it hasn't
+// been already
+func (v *VM) force(b *binding) (interface{}, error) {
+	if b.done {
+		return b.value, nil
+	}
+	if b.pending {
+		return nil, v.cycleError(b)
+	}
+	b.pending = true
+	v.forcing = append(v.forcing, b)
+	oldEnv := v.env
+	v.env = b.env
+	val, err := v.evalBinding(b)
+	v.env = oldEnv
+	v.forcing = v.forcing[:len(v.forcing)-1]
+	b.pending = false
+	if err != nil {
+		return nil, err
+	}
+	b.value = val
+	b.done = true
+	return b.value, nil
+}
+
+// evalBinding evaluates the value of a binding in the current
+// environment
+func (v *VM) evalBinding(b *binding) (interface{}, error) {
+	if b.source == nil {
+		if err := v.evalNode(b.node); err != nil {
+			return nil, err
+		}
+		return v.pop(), nil
+	}
+	val, err := v.force(b.source)
+	if err != nil {
+		return nil, err
+	}
+	m, err := v.patternSource(b.pattern, val)
+	if err != nil {
+		return nil, err
+	}
+	return v.patternField(m, b.field)
+}
+
+// cycleError reports a binding that depends on itself, naming the
+// bindings in the cycle in the order they refer to one another
+func (v *VM) cycleError(b *binding) error {
+	var names []string
+	for i := len(v.forcing) - 1; i >= 0; i-- {
+		if v.forcing[i] == b {
+			for _, f := range v.forcing[i:] {
+				names = append(names, f.key.Text)
+			}
+			break
+		}
+	}
+	names = append(names, b.key.Text)
+	return v.wrapError(&b.key, fmt.Errorf("let bindings form a cycle: %s", strings.Join(names, " -> ")))
+}
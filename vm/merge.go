@@ -0,0 +1,227 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/wycleffsean/nostos/lang"
+	"github.com/wycleffsean/nostos/pkg/ordered"
+)
+
+// listStrategy is how a deep merge combines two lists
+type listStrategy string
+
+const (
+	listReplace listStrategy = "replace" // the overriding list wins
+	listAppend  listStrategy = "append"  // overriding items follow the base items
+	listMerge   listStrategy = "merge"   // items with the same merge key are merged
+)
+
+type mergeOptions struct {
+	lists    listStrategy            // strategy for lists without a field strategy
+	fields   map[string]listStrategy // strategies by the name of the field holding the list
+	mergeKey string                  // field identifying list items for listMerge
+}
+
+// defaultMergeOptions are used by the << operator and merge keys
+var defaultMergeOptions = mergeOptions{lists: listReplace, mergeKey: "name"}
+
+func (o *mergeOptions) strategy(field string) listStrategy {
+	if s, ok := o.fields[field]; ok {
+		return s
+	}
+	return o.lists
+}
+
+// deepMerge merges over into base without modifying either.
+// Maps are merged key by key, lists according to the strategy for
+// the field holding them, and any other value in over replaces
+// the one in base. Keys of base keep their place, new keys from
+// over follow them.
+func deepMerge(base, over interface{}, opts *mergeOptions, field string) interface{} {
+	switch b := base.(type) {
+	case *ordered.Map:
+		o, ok := over.(*ordered.Map)
+		if !ok {
+			return over
+		}
+		merged := b.Copy()
+		for _, k := range o.Keys() {
+			val, _ := o.Get(k)
+			if prev, ok := merged.Get(k); ok {
+				merged.Set(k, deepMerge(prev, val, opts, k))
+			} else {
+				merged.Set(k, val)
+			}
+		}
+		return merged
+	case []interface{}:
+		o, ok := over.([]interface{})
+		if !ok {
+			return over
+		}
+		switch opts.strategy(field) {
+		case listAppend:
+			return append(append(make([]interface{}, 0, len(b)+len(o)), b...), o...)
+		case listMerge:
+			return mergeByKey(b, o, opts)
+		default:
+			return over
+		}
+	default:
+		return over
+	}
+}
+
+// mergeByKey merges the items of over into the items of base
+// having the same value for the merge key. Other items of over
+// are appended.
+func mergeByKey(base, over []interface{}, opts *mergeOptions) []interface{} {
+	merged := append(make([]interface{}, 0, len(base)+len(over)), base...)
+	for _, item := range over {
+		key, ok := mergeKeyOf(item, opts.mergeKey)
+		if !ok {
+			merged = append(merged, item)
+			continue
+		}
+		found := false
+		for i, existing := range merged {
+			if existingKey, ok := mergeKeyOf(existing, opts.mergeKey); ok && ordered.Equal(key, existingKey) {
+				merged[i] = deepMerge(existing, item, opts, "")
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+func mergeKeyOf(item interface{}, key string) (interface{}, bool) {
+	m, ok := item.(*ordered.Map)
+	if !ok {
+		return nil, false
+	}
+	return m.Get(key)
+}
+
+func (v *VM) evalShovel(node *lang.Shovel) error {
+	if err := v.evalNode(node.Left); err != nil {
+		return err
+	}
+	left := v.pop()
+	if err := v.evalNode(node.Right); err != nil {
+		return err
+	}
+	right := v.pop()
+	_, lok := left.(*ordered.Map)
+	_, rok := right.(*ordered.Map)
+	if !lok || !rok {
+		return v.errorAt(node.OperatorPos, fmt.Errorf("operator << requires maps, got %s and %s", typeName(left), typeName(right)))
+	}
+	v.push(deepMerge(left, right, &defaultMergeOptions, ""))
+	return nil
+}
+
+// mergeKeyBase returns what the value of a merge key, `<<: base`,
+// merges into its map. It's a map or a list of maps, in which
+// case earlier maps take precedence as they do in YAML.
+func mergeKeyBase(val interface{}) (*ordered.Map, error) {
+	switch b := val.(type) {
+	case *ordered.Map:
+		return b, nil
+	case []interface{}:
+		merged := &ordered.Map{}
+		for i := len(b) - 1; i >= 0; i-- {
+			m, ok := b[i].(*ordered.Map)
+			if !ok {
+				return nil, fmt.Errorf("merge key list items must be maps, got %s", typeName(b[i]))
+			}
+			merged = deepMerge(merged, m, &defaultMergeOptions, "").(*ordered.Map)
+		}
+		return merged, nil
+	default:
+		return nil, fmt.Errorf("merge key value must be a map or a list of maps, got %s", typeName(val))
+	}
+}
+
+// builtinMerge is the configurable form of <<:
+//
+//	merge(base, overrides)
+//	merge(base, overrides, {lists: "append"})
+//	merge(base, overrides, {lists: "merge", mergeKey: "name", fields: {args: "append"}})
+//
+// lists sets the strategy for every list, fields overrides it for
+// lists held by fields of a given name.
+func builtinMerge(v *VM, args ...interface{}) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("merge expects 2 or 3 arguments, got %d", len(args))
+	}
+	base, bok := args[0].(*ordered.Map)
+	over, ook := args[1].(*ordered.Map)
+	if !bok || !ook {
+		return fmt.Errorf("merge expects maps, got %s and %s", typeName(args[0]), typeName(args[1]))
+	}
+	opts := defaultMergeOptions
+	if len(args) == 3 {
+		var err error
+		if opts, err = parseMergeOptions(args[2]); err != nil {
+			return err
+		}
+	}
+	v.push(deepMerge(base, over, &opts, ""))
+	return nil
+}
+
+func parseMergeOptions(val interface{}) (mergeOptions, error) {
+	opts := defaultMergeOptions
+	m, ok := val.(*ordered.Map)
+	if !ok {
+		return opts, fmt.Errorf("merge options must be a map, got %s", typeName(val))
+	}
+	for _, k := range m.Keys() {
+		option, _ := m.Get(k)
+		switch k {
+		case "lists":
+			s, err := parseListStrategy(option)
+			if err != nil {
+				return opts, err
+			}
+			opts.lists = s
+		case "mergeKey":
+			key, ok := option.(string)
+			if !ok {
+				return opts, fmt.Errorf("mergeKey must be a string, got %s", typeName(option))
+			}
+			opts.mergeKey = key
+		case "fields":
+			fields, ok := option.(*ordered.Map)
+			if !ok {
+				return opts, fmt.Errorf("fields must be a map, got %s", typeName(option))
+			}
+			opts.fields = make(map[string]listStrategy, fields.Len())
+			for _, field := range fields.Keys() {
+				s, _ := fields.Get(field)
+				strategy, err := parseListStrategy(s)
+				if err != nil {
+					return opts, err
+				}
+				opts.fields[field] = strategy
+			}
+		default:
+			return opts, fmt.Errorf("unknown merge option %s", k)
+		}
+	}
+	return opts, nil
+}
+
+func parseListStrategy(val interface{}) (listStrategy, error) {
+	s, _ := val.(string)
+	switch strategy := listStrategy(s); strategy {
+	case listReplace, listAppend, listMerge:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown list strategy %v, expected replace, append or merge", val)
+	}
+}
@@ -0,0 +1,160 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/wycleffsean/nostos/lang"
+	"github.com/wycleffsean/nostos/pkg/ordered"
+	"github.com/wycleffsean/nostos/pkg/urispec"
+)
+
+func (v *VM) evalBinary(node *lang.BinaryOp) error {
+	if err := v.evalNode(node.Left); err != nil {
+		return err
+	}
+	left := v.pop()
+
+	// && and || short circuit, the right operand is only
+	// evaluated when it decides the result
+	if node.Operator == "&&" || node.Operator == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return v.errorAt(node.OperatorPos, fmt.Errorf("operator %s requires booleans, got %s", node.Operator, typeName(left)))
+		}
+		if l == (node.Operator == "||") {
+			v.push(l)
+			return nil
+		}
+		if err := v.evalNode(node.Right); err != nil {
+			return err
+		}
+		right := v.pop()
+		r, ok := right.(bool)
+		if !ok {
+			return v.errorAt(node.OperatorPos, fmt.Errorf("operator %s requires booleans, got %s", node.Operator, typeName(right)))
+		}
+		v.push(r)
+		return nil
+	}
+
+	if err := v.evalNode(node.Right); err != nil {
+		return err
+	}
+	right := v.pop()
+
+	res, err := binaryOp(node.Operator, left, right)
+	if err != nil {
+		return v.errorAt(node.OperatorPos, err)
+	}
+	v.push(res)
+	return nil
+}
+
+func binaryOp(op string, left, right interface{}) (interface{}, error) {
+	switch op {
+	case "==":
+		return ordered.Equal(left, right), nil
+	case "!=":
+		return !ordered.Equal(left, right), nil
+	}
+
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			switch op {
+			case "+":
+				return l + r, nil
+			case "<":
+				return l < r, nil
+			case "<=":
+				return l <= r, nil
+			case ">":
+				return l > r, nil
+			case ">=":
+				return l >= r, nil
+			}
+		}
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operator %s is not defined on %s and %s", op, typeName(left), typeName(right))
+	}
+	switch op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(l, r), nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %s", op)
+	}
+}
+
+func (v *VM) evalUnary(node *lang.UnaryOp) error {
+	if err := v.evalNode(node.Operand); err != nil {
+		return err
+	}
+	operand := v.pop()
+	switch node.Operator {
+	case "-":
+		n, ok := operand.(float64)
+		if !ok {
+			return v.errorAt(node.Position, fmt.Errorf("operator - requires a number, got %s", typeName(operand)))
+		}
+		v.push(-n)
+	case "!":
+		b, ok := operand.(bool)
+		if !ok {
+			return v.errorAt(node.Position, fmt.Errorf("operator ! requires a boolean, got %s", typeName(operand)))
+		}
+		v.push(!b)
+	default:
+		return v.errorAt(node.Position, fmt.Errorf("unknown operator %s", node.Operator))
+	}
+	return nil
+}
+
+// typeName names the type of an evaluated value for error messages
+func typeName(val interface{}) string {
+	switch val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case urispec.Spec:
+		return "path"
+	case *ordered.Map:
+		return "map"
+	case []interface{}:
+		return "list"
+	case *closure:
+		return "function"
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
@@ -0,0 +1,66 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/wycleffsean/nostos/lang"
+	"github.com/wycleffsean/nostos/pkg/ordered"
+)
+
+// bindParam binds val in env to a function parameter or
+// comprehension variable, a *lang.Symbol or *lang.Pattern
+func (v *VM) bindParam(param interface{}, val interface{}, env map[string]interface{}) error {
+	switch param := param.(type) {
+	case *lang.Symbol:
+		env[param.Text] = val
+	case *lang.Pattern:
+		return v.destructure(param, val, env)
+	}
+	return nil
+}
+
+// destructure binds the fields of pattern from val into env.
+// Defaults are evaluated in env, so a default can refer to the
+// fields before it.
+func (v *VM) destructure(pattern *lang.Pattern, val interface{}, env map[string]interface{}) error {
+	m, err := v.patternSource(pattern, val)
+	if err != nil {
+		return err
+	}
+	oldEnv := v.env
+	v.env = env
+	defer func() { v.env = oldEnv }()
+	for _, field := range pattern.Fields {
+		fv, err := v.patternField(m, field)
+		if err != nil {
+			return err
+		}
+		env[field.Name.Text] = fv
+	}
+	return nil
+}
+
+// patternSource checks that val, being destructured by pattern,
+// is a map
+func (v *VM) patternSource(pattern *lang.Pattern, val interface{}) (*ordered.Map, error) {
+	m, ok := val.(*ordered.Map)
+	if !ok {
+		return nil, v.wrapError(pattern, fmt.Errorf("cannot destructure %s, expected a map", typeName(val)))
+	}
+	return m, nil
+}
+
+// patternField returns the value of field in m, or its default
+// evaluated in the current environment
+func (v *VM) patternField(m *ordered.Map, field *lang.PatternField) (interface{}, error) {
+	if val, ok := m.Get(field.Name.Text); ok {
+		return val, nil
+	}
+	if field.Default == nil {
+		return nil, v.wrapError(&field.Name, missingKey("field", field.Name.Text, m))
+	}
+	if err := v.evalNode(field.Default); err != nil {
+		return nil, err
+	}
+	return v.pop(), nil
+}
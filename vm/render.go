@@ -0,0 +1,52 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/wycleffsean/nostos/pkg/ordered"
+)
+
+// checkRenderable reports a function left in the value of a
+// document. Functions have no YAML form, a function value in the
+// output is one that was never called or wasn't given all of its
+// arguments. The error is at the function's definition.
+func checkRenderable(val interface{}, path string) error {
+	switch val := val.(type) {
+	case *closure:
+		what := "the document"
+		if path != "" {
+			what = path
+		}
+		msg := fmt.Sprintf("cannot render %s, it's a function", what)
+		if len(val.args) > 0 {
+			msg += fmt.Sprintf(" awaiting %s", arguments(len(val.fn.Params)-len(val.args)))
+		}
+		return &EvalError{File: val.file, Position: val.fn.Pos(), EndPosition: val.fn.End(), Msg: msg}
+	case *ordered.Map:
+		for _, k := range val.Keys() {
+			child, _ := val.Get(k)
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			if err := checkRenderable(child, childPath); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			if err := checkRenderable(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// arguments is a count of function arguments, "1 argument"
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
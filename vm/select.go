@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/wycleffsean/nostos/lang"
+	"github.com/wycleffsean/nostos/pkg/ordered"
+)
+
+func (v *VM) evalSelect(node *lang.Select) error {
+	if text, ok := v.unboundPath(node); ok {
+		v.push(text)
+		return nil
+	}
+	if err := v.evalNode(node.Expr); err != nil {
+		return err
+	}
+	target := v.pop()
+	m, ok := target.(*ordered.Map)
+	if !ok {
+		return v.errorAt(node.FieldPos, fmt.Errorf("cannot select field %s from %s", node.Field, typeName(target)))
+	}
+	val, ok := m.Get(node.Field)
+	if !ok {
+		return v.errorAt(node.FieldPos, missingKey("field", node.Field, m))
+	}
+	v.push(val)
+	return nil
+}
+
+// unboundPath returns the text of a dotted symbol whose root
+// isn't bound, such as an image name like nginx.io/nginx, which
+// is a plain scalar the same way an unbound symbol is
+func (v *VM) unboundPath(node *lang.Select) (string, bool) {
+	fields := []string{node.Field}
+	expr := node.Expr
+	for {
+		sel, ok := expr.(*lang.Select)
+		if !ok {
+			break
+		}
+		fields = append(fields, sel.Field)
+		expr = sel.Expr
+	}
+	root, ok := expr.(*lang.Symbol)
+	if !ok {
+		return "", false
+	}
+	if _, bound := v.env[root.Text]; bound {
+		return "", false
+	}
+	text := root.Text
+	for i := len(fields) - 1; i >= 0; i-- {
+		text += "." + fields[i]
+	}
+	return text, true
+}
+
+func (v *VM) evalIndex(node *lang.Index) error {
+	if err := v.evalNode(node.Expr); err != nil {
+		return err
+	}
+	target := v.pop()
+	if err := v.evalNode(node.Key); err != nil {
+		return err
+	}
+	key := v.pop()
+
+	switch t := target.(type) {
+	case []interface{}:
+		n, ok := key.(float64)
+		if !ok || n != math.Trunc(n) {
+			return v.wrapError(node.Key, fmt.Errorf("list index must be a whole number, got %s", typeName(key)))
+		}
+		if n < 0 || int(n) >= len(t) {
+			return v.wrapError(node.Key, fmt.Errorf("index %d out of range for list of length %d", int(n), len(t)))
+		}
+		v.push(t[int(n)])
+	case *ordered.Map:
+		k, ok := key.(string)
+		if !ok {
+			return v.wrapError(node.Key, fmt.Errorf("map key must be a string, got %s", typeName(key)))
+		}
+		val, ok := t.Get(k)
+		if !ok {
+			return v.wrapError(node.Key, missingKey("key", k, t))
+		}
+		v.push(val)
+	default:
+		return v.errorAt(node.BracketPos, fmt.Errorf("cannot index %s", typeName(target)))
+	}
+	return nil
+}
+
+// missingKey reports a field absent from a map, listing the
+// fields that are there
+func missingKey(kind, name string, m *ordered.Map) error {
+	if m.Len() == 0 {
+		return fmt.Errorf("unknown %s %q, the map is empty", kind, name)
+	}
+	return fmt.Errorf("unknown %s %q, available %ss: %s", kind, name, kind, strings.Join(m.Keys(), ", "))
+}
@@ -0,0 +1,311 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// String builtins take the string they work on first, in the
+// order of their counterparts in Go's strings package.
+
+func builtinUpper(v *VM, args ...interface{}) error {
+	return v.mapString("upper", args, strings.ToUpper)
+}
+
+func builtinLower(v *VM, args ...interface{}) error {
+	return v.mapString("lower", args, strings.ToLower)
+}
+
+// builtinTrim removes leading and trailing whitespace
+func builtinTrim(v *VM, args ...interface{}) error {
+	return v.mapString("trim", args, strings.TrimSpace)
+}
+
+// mapString pushes f applied to the single string argument of the
+// builtin name
+func (v *VM) mapString(name string, args []interface{}, f func(string) string) error {
+	if err := arity(name, args, 1, 1); err != nil {
+		return err
+	}
+	s, err := stringArg(name, args, 0)
+	if err != nil {
+		return err
+	}
+	v.push(f(s))
+	return nil
+}
+
+// builtinReplace replaces every occurrence of old in s,
+// replace(s, old, new)
+func builtinReplace(v *VM, args ...interface{}) error {
+	strs, err := stringArgs("replace", args, 3)
+	if err != nil {
+		return err
+	}
+	v.push(strings.ReplaceAll(strs[0], strs[1], strs[2]))
+	return nil
+}
+
+// builtinSplit splits s around each sep, split(s, sep)
+func builtinSplit(v *VM, args ...interface{}) error {
+	strs, err := stringArgs("split", args, 2)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(strs[0], strs[1])
+	list := make([]interface{}, len(parts))
+	for i, part := range parts {
+		list[i] = part
+	}
+	v.push(list)
+	return nil
+}
+
+// builtinJoin joins a list of strings with sep between them,
+// join(list, sep)
+func builtinJoin(v *VM, args ...interface{}) error {
+	if err := arity("join", args, 2, 2); err != nil {
+		return err
+	}
+	list, ok := args[0].([]interface{})
+	if !ok {
+		return &argError{0, fmt.Errorf("join expects a list, got %s", typeName(args[0]))}
+	}
+	sep, err := stringArg("join", args, 1)
+	if err != nil {
+		return err
+	}
+	strs := make([]string, len(list))
+	for i, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return &argError{0, fmt.Errorf("join expects a list of strings, item %d is a %s", i, typeName(item))}
+		}
+		strs[i] = s
+	}
+	v.push(strings.Join(strs, sep))
+	return nil
+}
+
+// builtinHasPrefix reports whether s begins with prefix,
+// hasPrefix(s, prefix)
+func builtinHasPrefix(v *VM, args ...interface{}) error {
+	strs, err := stringArgs("hasPrefix", args, 2)
+	if err != nil {
+		return err
+	}
+	v.push(strings.HasPrefix(strs[0], strs[1]))
+	return nil
+}
+
+// builtinHasSuffix reports whether s ends with suffix,
+// hasSuffix(s, suffix)
+func builtinHasSuffix(v *VM, args ...interface{}) error {
+	strs, err := stringArgs("hasSuffix", args, 2)
+	if err != nil {
+		return err
+	}
+	v.push(strings.HasSuffix(strs[0], strs[1]))
+	return nil
+}
+
+// builtinSubstr returns the characters of s from start, through to
+// the end or length of them, substr(s, start) or
+// substr(s, start, length). Characters are counted as runes.
+func builtinSubstr(v *VM, args ...interface{}) error {
+	if err := arity("substr", args, 2, 3); err != nil {
+		return err
+	}
+	s, err := stringArg("substr", args, 0)
+	if err != nil {
+		return err
+	}
+	runes := []rune(s)
+	start, err := intArg("substr", args, 1)
+	if err != nil {
+		return err
+	}
+	if start < 0 || start > len(runes) {
+		return &argError{1, fmt.Errorf("substr start %d out of range for string of length %d", start, len(runes))}
+	}
+	end := len(runes)
+	if len(args) == 3 {
+		length, err := intArg("substr", args, 2)
+		if err != nil {
+			return err
+		}
+		if length < 0 || start+length > len(runes) {
+			return &argError{2, fmt.Errorf("substr length %d out of range for string of length %d from %d", length, len(runes), start)}
+		}
+		end = start + length
+	}
+	v.push(string(runes[start:end]))
+	return nil
+}
+
+// builtinRegexMatch reports whether s contains a match of the RE2
+// pattern, regexMatch(s, pattern)
+func builtinRegexMatch(v *VM, args ...interface{}) error {
+	if err := arity("regexMatch", args, 2, 2); err != nil {
+		return err
+	}
+	s, err := stringArg("regexMatch", args, 0)
+	if err != nil {
+		return err
+	}
+	re, err := regexArg("regexMatch", args, 1)
+	if err != nil {
+		return err
+	}
+	v.push(re.MatchString(s))
+	return nil
+}
+
+// builtinRegexReplace replaces the matches of the RE2 pattern in s.
+// $1 or ${name} in the replacement stand for submatches,
+// regexReplace(s, pattern, replacement)
+func builtinRegexReplace(v *VM, args ...interface{}) error {
+	if err := arity("regexReplace", args, 3, 3); err != nil {
+		return err
+	}
+	s, err := stringArg("regexReplace", args, 0)
+	if err != nil {
+		return err
+	}
+	re, err := regexArg("regexReplace", args, 1)
+	if err != nil {
+		return err
+	}
+	repl, err := stringArg("regexReplace", args, 2)
+	if err != nil {
+		return err
+	}
+	v.push(re.ReplaceAllString(s, repl))
+	return nil
+}
+
+// stringArgs checks that a builtin was given n arguments, all of
+// them strings
+func stringArgs(name string, args []interface{}, n int) ([]string, error) {
+	if err := arity(name, args, n, n); err != nil {
+		return nil, err
+	}
+	strs := make([]string, n)
+	for i := range args {
+		s, err := stringArg(name, args, i)
+		if err != nil {
+			return nil, err
+		}
+		strs[i] = s
+	}
+	return strs, nil
+}
+
+// regexArg compiles argument i of a builtin as an RE2 pattern
+func regexArg(name string, args []interface{}, i int) (*regexp.Regexp, error) {
+	pattern, err := stringArg(name, args, i)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, &argError{i, fmt.Errorf("%s: %v", name, err)}
+	}
+	return re, nil
+}
+
+// builtinFormat formats its arguments the way Go's fmt.Sprintf does,
+// format("%s-%03d", name, 7). Numbers given to integer verbs such as
+// %d must be whole, strings and booleans print as they interpolate.
+func builtinFormat(v *VM, args ...interface{}) error {
+	if err := arity("format", args, 1, -1); err != nil {
+		return err
+	}
+	format, err := stringArg("format", args, 0)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	next := 1 // the argument for the next verb
+	for i := 0; i < len(format); {
+		if format[i] != '%' {
+			r, size := utf8.DecodeRuneInString(format[i:])
+			b.WriteRune(r)
+			i += size
+			continue
+		}
+		spec := formatVerb.FindString(format[i:])
+		if spec == "" {
+			return &argError{0, fmt.Errorf("format: invalid verb at %q", format[i:])}
+		}
+		i += len(spec)
+		verb := spec[len(spec)-1]
+		if verb == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		if next >= len(args) {
+			return &argError{0, fmt.Errorf("format: missing argument for %s", spec)}
+		}
+		arg, err := formatArg(verb, args[next])
+		if err != nil {
+			return &argError{next, fmt.Errorf("format: %s %v", spec, err)}
+		}
+		fmt.Fprintf(&b, spec, arg)
+		next++
+	}
+	if next < len(args) {
+		return &argError{next, fmt.Errorf("format: more arguments than verbs")}
+	}
+	v.push(b.String())
+	return nil
+}
+
+// formatVerb matches a verb with its flags, width and precision
+var formatVerb = regexp.MustCompile(`^%[-+# 0]*[0-9]*(\.[0-9]*)?[a-zA-Z%]`)
+
+// formatArg converts val to the Go value the verb expects
+func formatArg(verb byte, val interface{}) (interface{}, error) {
+	switch verb {
+	case 'd', 'b', 'o', 'c', 'U':
+		n, ok := val.(float64)
+		if !ok || n != math.Trunc(n) {
+			return nil, fmt.Errorf("expects a whole number, got %s", describe(val))
+		}
+		return int64(n), nil
+	case 'x', 'X':
+		switch n := val.(type) {
+		case string:
+			return n, nil
+		case float64:
+			if n == math.Trunc(n) {
+				return int64(n), nil
+			}
+		}
+		return nil, fmt.Errorf("expects a whole number or string, got %s", describe(val))
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		if _, ok := val.(float64); !ok {
+			return nil, fmt.Errorf("expects a number, got %s", typeName(val))
+		}
+		return val, nil
+	case 't':
+		if _, ok := val.(bool); !ok {
+			return nil, fmt.Errorf("expects a boolean, got %s", typeName(val))
+		}
+		return val, nil
+	case 's', 'q', 'v':
+		if val == nil {
+			return "null", nil
+		}
+		s, ok := interpolate(val)
+		if !ok {
+			return nil, fmt.Errorf("cannot format %s", typeName(val))
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("is not a supported verb")
+	}
+}